@@ -20,8 +20,8 @@ type Article struct {
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) ArticleCreate(title, web string, date int64, createdBy string, newspaper int64) (id int64, verr *ValidationError, err error) {
-	verr = articleValidate(title, web, date)
+func (dbp *DBProvider) ArticleCreate(title, web string, date int64, createdBy string, newspaper int64) (id int64, verr ValidationErrors, err error) {
+	verr = ArticleValidate(title, web, date)
 	if verr != nil {
 		return
 	}
@@ -40,7 +40,7 @@ func (dbp *DBProvider) ArticleCreate(title, web string, date int64, createdBy st
 	result, err := stmt.Exec(title, web, date, createdBy, createdBy, ts, ts, newspaper)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -52,8 +52,8 @@ func (dbp *DBProvider) ArticleCreate(title, web string, date int64, createdBy st
 	}
 	return
 }
-func (dbp *DBProvider) ArticleUpdate(id int64, title, web string, date int64, updatedBy string, newspaper int64) (numRows int64, verr *ValidationError, err error) {
-	verr = articleValidate(title, web, date)
+func (dbp *DBProvider) ArticleUpdate(id int64, title, web string, date int64, updatedBy string, newspaper int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = ArticleValidate(title, web, date)
 	if verr != nil {
 		return
 	}
@@ -72,7 +72,7 @@ func (dbp *DBProvider) ArticleUpdate(id int64, title, web string, date int64, up
 	result, err := stmt.Exec(title, web, date, updatedBy, ts, newspaper, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -104,6 +104,13 @@ func (dbp *DBProvider) ArticleDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.categoryUntagAll(EntityTypeArticle, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeArticle, id)
+	}
 	return
 }
 func (dbp *DBProvider) ArticleGetAll() (articles []*Article, err error) {
@@ -218,6 +225,37 @@ func (dbp *DBProvider) ArticleGetByResearchLine(researchLineId int64) (articles
 	}
 	return
 }
+func (dbp *DBProvider) ArticleGetByCategory(categoryId int64) (articles []*Article, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT article.* FROM category_tag INNER JOIN article ON category_tag.entity_id=article.id WHERE category_tag.category=? AND category_tag.entity_type=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId, EntityTypeArticle)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Article{}
+		err = rows.Scan(&p.Id, &p.Title, &p.Web, &p.Date, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.Newspaper)
+		if err != nil {
+			return
+		}
+		articles = append(articles, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) ArticleCount() (count int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -275,12 +313,12 @@ func (dbp *DBProvider) ArticleAddResearchLine(id, researchLineId int64, createdB
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research_line has already been added"}
+			verr = &ValidationError{"research_line", "this research_line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -340,23 +378,17 @@ func articleValidateTitle(title string) (verr *ValidationError) {
 	return validateLength("title", title, 200)
 }
 func articleValidateWeb(web string) (verr *ValidationError) {
-	return validateLength("web", web, 200)
+	if verr = validateLength("web", web, 200); verr != nil {
+		return verr
+	}
+	return validateURL("web", web)
 }
 func articleValidateDate(date int64) (verr *ValidationError) {
-	return validateIsNumber("date", date)
+	return validateDate("date", date)
 }
-func articleValidate(title, web string, date int64) (verr *ValidationError) {
-	verr = articleValidateTitle(title)
-	if verr != nil {
-		return
-	}
-	verr = articleValidateWeb(web)
-	if verr != nil {
-		return
-	}
-	verr = articleValidateDate(date)
-	if verr != nil {
-		return
-	}
+func ArticleValidate(title, web string, date int64) (verrs ValidationErrors) {
+	verrs.add(articleValidateTitle(title))
+	verrs.add(articleValidateWeb(web))
+	verrs.add(articleValidateDate(date))
 	return
 }
@@ -0,0 +1,530 @@
+package instantolib
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Asset fields are the columns of entities that point to a file
+const (
+	AssetFieldCv    = "cv"
+	AssetFieldPhoto = "photo"
+	AssetFieldLogo  = "logo"
+)
+
+// assetTargets maps the entity types with assets to their table and asset fields.
+// The asset fields are also the columns holding the URL of the asset.
+var assetTargets = map[string]struct {
+	table  string
+	fields []string
+}{
+	EntityTypeMember:       {"member", []string{AssetFieldCv, AssetFieldPhoto}},
+	EntityTypePartner:      {"partner", []string{AssetFieldLogo}},
+	EntityTypeNewspaper:    {"newspaper", []string{AssetFieldLogo}},
+	EntityTypeResearchArea: {"research_area", []string{AssetFieldLogo}},
+	EntityTypeResearchLine: {"research_line", []string{AssetFieldLogo}},
+}
+
+// Asset links a field of an entity, such as the CV of a member, to the resource holding the file.
+// The resource is deleted when it is replaced or the entity is deleted, unless something else references it.
+type Asset struct {
+	Id         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Field      string `json:"field"`
+	Resource   int64  `json:"resource"`
+	CreatedBy  string `json:"created_by"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// AssetMigrationIssue is a legacy value of an asset field that AssetMigrate could not link to a resource
+type AssetMigrationIssue struct {
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Field      string `json:"field"`
+	Value      string `json:"value"`
+	Reason     string `json:"reason"`
+}
+
+// ResourceURL returns the URL of a resource under the base URL set with SetResourceBaseURL
+func (dbp *DBProvider) ResourceURL(resource *Resource) string {
+	return strings.TrimSuffix(dbp.resourceBaseURL, "/") + "/" + strconv.FormatInt(resource.Id, 10) + "/" + (&url.URL{Path: resource.Filename}).EscapedPath()
+}
+
+// AssetSet makes the field of an entity point to the resource, replacing the previous resource or image.
+// The column of the field is set to the URL of the resource.
+func (dbp *DBProvider) AssetSet(entityType string, id int64, field string, resourceId int64, updatedBy string) (verr *ValidationError, err error) {
+	if verr = assetValidateField(entityType, field); verr != nil {
+		return
+	}
+	target := assetTargets[entityType]
+	exists, err := dbp.rowExists(target.table, id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		verr = &ValidationError{entityType, "not exist", ValidationCodeNotFound}
+		return
+	}
+	resource, err := dbp.ResourceGetById(resourceId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"resource", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var previous int64
+	err = tx.QueryRow("SELECT resource FROM asset WHERE entity_type=? AND entity_id=? AND field=? FOR UPDATE", entityType, id, field).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	ts := time.Now().Unix()
+	_, err = txExec(tx, "INSERT INTO asset(entity_type,entity_id,field,resource,created_by,created_at) VALUES(?,?,?,?,?,?) ON DUPLICATE KEY UPDATE resource=VALUES(resource),created_by=VALUES(created_by),created_at=VALUES(created_at)", entityType, id, field, resourceId, updatedBy, ts)
+	if err != nil {
+		return
+	}
+	// target and field come from assetTargets, never from the caller
+	_, err = txExec(tx, "UPDATE "+target.table+" SET "+field+"=?,updated_by=?,updated_at=? WHERE id=?", dbp.ResourceURL(resource), updatedBy, ts, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	if field != AssetFieldCv {
+		// the resource replaces any uploaded image
+		err = dbp.imageDeleteAll(entityType, id)
+		if err != nil {
+			return
+		}
+	}
+	if previous != 0 && previous != resourceId {
+		err = dbp.assetRelease(previous)
+	}
+	return
+}
+
+// AssetClear empties the field of an entity and releases its resource
+func (dbp *DBProvider) AssetClear(entityType string, id int64, field string, updatedBy string) (removed bool, verr *ValidationError, err error) {
+	if verr = assetValidateField(entityType, field); verr != nil {
+		return
+	}
+	target := assetTargets[entityType]
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE " + target.table + " SET " + field + "='',updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(updatedBy, time.Now().Unix(), id)
+	if err != nil {
+		return
+	}
+	assets, err := dbp.AssetGetByEntity(entityType, id)
+	if err != nil {
+		return
+	}
+	for _, asset := range assets {
+		if asset.Field == field {
+			removed = true
+		}
+	}
+	err = dbp.assetDetach(entityType, id, field)
+	return
+}
+
+// AssetGetResource returns the resource of the field of an entity, or sql.ErrNoRows if it has none
+func (dbp *DBProvider) AssetGetResource(entityType string, id int64, field string) (resource *Resource, err error) {
+	resource = &Resource{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT resource.* FROM asset INNER JOIN resource ON asset.resource=resource.id WHERE asset.entity_type=? AND asset.entity_id=? AND asset.field=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(entityType, id, field).Scan(&resource.Id, &resource.Filename, &resource.MimeType, &resource.Size, &resource.Private, &resource.CreatedBy, &resource.UpdatedBy, &resource.CreatedAt, &resource.UpdatedAt, &resource.ResourceType, (*nullString)(&resource.BlobKey), (*nullString)(&resource.Checksum))
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) AssetGetByEntity(entityType string, id int64) (assets []*Asset, err error) {
+	assets, err = dbp.assetQuery("SELECT * FROM asset WHERE entity_type=? AND entity_id=? ORDER BY field ASC", entityType, id)
+	return
+}
+func (dbp *DBProvider) AssetGetByResource(resourceId int64) (assets []*Asset, err error) {
+	assets, err = dbp.assetQuery("SELECT * FROM asset WHERE resource=? ORDER BY entity_type ASC,entity_id ASC,field ASC", resourceId)
+	return
+}
+func (dbp *DBProvider) assetQuery(query string, args ...interface{}) (assets []*Asset, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Asset{}
+		err = rows.Scan(&p.Id, &p.EntityType, &p.EntityId, &p.Field, &p.Resource, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		assets = append(assets, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// assetRemove removes the asset of the field of an entity and releases its resource
+func (dbp *DBProvider) assetRemove(entityType string, id int64, field string) (removed bool, err error) {
+	assets, err := dbp.AssetGetByEntity(entityType, id)
+	if err != nil {
+		return
+	}
+	for _, asset := range assets {
+		if asset.Field != field {
+			continue
+		}
+		removed, err = dbp.assetDelete(asset)
+		return
+	}
+	return
+}
+
+// assetDetach releases the resource and images of a field that is set to an unmanaged value
+func (dbp *DBProvider) assetDetach(entityType string, id int64, field string) (err error) {
+	_, err = dbp.assetRemove(entityType, id, field)
+	if err != nil {
+		return
+	}
+	if field != AssetFieldCv {
+		err = dbp.imageDeleteAll(entityType, id)
+	}
+	return
+}
+
+// assetDeleteAll removes the assets of a deleted entity, which no foreign key removes, and releases their resources
+func (dbp *DBProvider) assetDeleteAll(entityType string, id int64) (err error) {
+	assets, err := dbp.AssetGetByEntity(entityType, id)
+	if err != nil {
+		return
+	}
+	for _, asset := range assets {
+		if _, err = dbp.assetDelete(asset); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mergeAssetRelation gives keepId the fields of dropId that keepId leaves empty, with their resources and images.
+// keepValues and dropValues hold the values of the asset fields of both entities. The assets of dropId that are
+// not moved are reported as skipped and must be released with assetDeleteAll once dropId is deleted.
+func mergeAssetRelation(tx *sql.Tx, entityType string, keepId, dropId int64, keepValues, dropValues map[string]string, updatedBy string, ts int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: "assets", Table: "asset"}
+	target := assetTargets[entityType]
+	for _, field := range target.fields {
+		if keepValues[field] != "" || dropValues[field] == "" {
+			continue
+		}
+		// target and field come from assetTargets, never from the caller
+		_, err = txExec(tx, "UPDATE "+target.table+" SET "+field+"=?,updated_by=?,updated_at=? WHERE id=?", dropValues[field], updatedBy, ts, keepId)
+		if err != nil {
+			return
+		}
+		var moved int64
+		moved, err = txExec(tx, "UPDATE asset SET entity_id=? WHERE entity_type=? AND entity_id=? AND field=?", keepId, entityType, dropId, field)
+		if err != nil {
+			return
+		}
+		rel.Moved += moved
+		if field != AssetFieldCv {
+			_, err = txExec(tx, "UPDATE image_variant SET entity_id=? WHERE entity_type=? AND entity_id=?", keepId, entityType, dropId)
+			if err != nil {
+				return
+			}
+		}
+	}
+	err = tx.QueryRow("SELECT COUNT(id) FROM asset WHERE entity_type=? AND entity_id=?", entityType, dropId).Scan(&rel.Skipped)
+	return
+}
+func (dbp *DBProvider) assetDelete(asset *Asset) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM asset WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(asset.Id)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+		err = dbp.assetRelease(asset.Resource)
+	}
+	return
+}
+
+// assetRelease deletes a resource that was used as an asset once nothing references it anymore
+func (dbp *DBProvider) assetRelease(resourceId int64) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT (SELECT COUNT(id) FROM asset WHERE resource=?)+(SELECT COUNT(id) FROM attachment WHERE resource=?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var refs int64
+	err = stmt.QueryRow(resourceId, resourceId).Scan(&refs)
+	if err != nil {
+		return
+	}
+	if refs != 0 {
+		return
+	}
+	_, err = dbp.ResourceDelete(resourceId)
+	return
+}
+
+// assetUnlinkResource empties the fields pointing to a resource that is being deleted
+func (dbp *DBProvider) assetUnlinkResource(resourceId int64) (err error) {
+	assets, err := dbp.AssetGetByResource(resourceId)
+	if err != nil || len(assets) == 0 {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	for _, asset := range assets {
+		target, ok := assetTargets[asset.EntityType]
+		if !ok {
+			continue
+		}
+		_, err = db.Exec("UPDATE "+target.table+" SET "+asset.Field+"='' WHERE id=?", asset.EntityId)
+		if err != nil {
+			return
+		}
+	}
+	_, err = db.Exec("DELETE FROM asset WHERE resource=?", resourceId)
+	return
+}
+
+// AssetMigrate links the legacy values of the asset fields to resources. A value that is the URL of a resource
+// is linked to it, and any other value is read as a path under root, uploaded as a resource of the type
+// resourceTypes gives for the field and linked to it. Fields with an uploaded image are left alone.
+// Values that cannot be migrated are returned as issues and kept as they are.
+func (dbp *DBProvider) AssetMigrate(root string, resourceTypes map[string]int64, createdBy string) (migrated []*Asset, issues []*AssetMigrationIssue, err error) {
+	type legacy struct {
+		entityType, field, value string
+		id                       int64
+	}
+	var values []*legacy
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	for _, entityType := range []string{EntityTypeMember, EntityTypePartner, EntityTypeNewspaper, EntityTypeResearchArea, EntityTypeResearchLine} {
+		target := assetTargets[entityType]
+		for _, field := range target.fields {
+			// table and field come from assetTargets, never from the caller
+			query := "SELECT t.id,t." + field + " FROM " + target.table + " t LEFT JOIN asset ON asset.entity_type=? AND asset.entity_id=t.id AND asset.field=? WHERE asset.id IS NULL AND t." + field + "<>''"
+			args := []interface{}{entityType, field}
+			if field != AssetFieldCv {
+				query += " AND NOT EXISTS (SELECT 1 FROM image_variant WHERE image_variant.entity_type=? AND image_variant.entity_id=t.id)"
+				args = append(args, entityType)
+			}
+			var rows *sql.Rows
+			rows, err = db.Query(query, args...)
+			if err != nil {
+				return
+			}
+			for rows.Next() {
+				p := legacy{entityType: entityType, field: field}
+				if err = rows.Scan(&p.id, &p.value); err != nil {
+					rows.Close()
+					return
+				}
+				values = append(values, &p)
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, v := range values {
+		var resourceId int64
+		var uploaded bool
+		var reason string
+		resourceId, uploaded, reason, err = dbp.assetMigrateValue(root, resourceTypes[v.field], v.value, createdBy)
+		if err != nil {
+			return
+		}
+		if reason == "" {
+			var verr *ValidationError
+			verr, err = dbp.AssetSet(v.entityType, v.id, v.field, resourceId, createdBy)
+			if err != nil {
+				return
+			}
+			if verr != nil {
+				reason = verr.Error()
+				// a resource uploaded for the value would be left without owner
+				if uploaded {
+					_, err = dbp.ResourceDelete(resourceId)
+					if err != nil {
+						return
+					}
+				}
+			}
+		}
+		if reason != "" {
+			issues = append(issues, &AssetMigrationIssue{v.entityType, v.id, v.field, v.value, reason})
+			continue
+		}
+		migrated = append(migrated, &Asset{EntityType: v.entityType, EntityId: v.id, Field: v.field, Resource: resourceId, CreatedBy: createdBy})
+	}
+	return
+}
+
+// assetMigrateValue finds or uploads the resource for a legacy value, or returns why it cannot.
+// uploaded tells whether the resource was created from a file under root.
+func (dbp *DBProvider) assetMigrateValue(root string, resourceType int64, value, createdBy string) (resourceId int64, uploaded bool, reason string, err error) {
+	p := value
+	if u, parseErr := url.Parse(value); parseErr == nil {
+		p = u.Path
+	}
+	if base := dbp.resourceBaseURL; base != "" && strings.HasPrefix(value, strings.TrimSuffix(base, "/")+"/") {
+		// a URL made by ResourceURL, base/id/filename
+		rest := strings.TrimPrefix(value, strings.TrimSuffix(base, "/")+"/")
+		id, parseErr := strconv.ParseInt(strings.SplitN(rest, "/", 2)[0], 10, 64)
+		if parseErr != nil {
+			reason = "not a resource URL"
+			return
+		}
+		var exists bool
+		exists, err = dbp.ResourceExists(id)
+		if err != nil {
+			return
+		}
+		if !exists {
+			reason = "the resource does not exist"
+			return
+		}
+		resourceId = id
+		return
+	}
+	if root == "" {
+		reason = "not a resource URL"
+		return
+	}
+	// the value is cleaned as an absolute path so it cannot point outside root
+	name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+p)))
+	info, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			reason = "file not found"
+			err = nil
+		}
+		return
+	}
+	if !info.Mode().IsRegular() {
+		reason = "not a file"
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	resourceId, verr, err := dbp.ResourceUpload(f, &ResourceMeta{Filename: filepath.Base(name), ResourceType: resourceType}, createdBy)
+	if err != nil {
+		return
+	}
+	if verr != nil {
+		reason = verr.Error()
+		return
+	}
+	uploaded = true
+	return
+}
+
+func (dbp *DBProvider) AssetGetColumns() []string {
+	return []string{"id", "entity_type", "entity_id", "field", "resource", "created_by", "created_at"}
+}
+
+func assetValidateField(entityType, field string) (verr *ValidationError) {
+	target, ok := assetTargets[entityType]
+	if !ok {
+		return &ValidationError{"entity_type", "has no assets", ValidationCodeInvalidValue}
+	}
+	for _, f := range target.fields {
+		if f == field {
+			return nil
+		}
+	}
+	return &ValidationError{"field", "must be one of " + strings.Join(target.fields, ", "), ValidationCodeInvalidValue}
+}
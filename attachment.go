@@ -0,0 +1,480 @@
+package instantolib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Entity types that take attachments besides the ones in entityTables
+const (
+	EntityTypeStudentWork = "student_work"
+	EntityTypeFundingBody = "funding_body"
+)
+
+// attachmentTables maps the entity types that take attachments to their table
+var attachmentTables = map[string]string{
+	EntityTypeMember:          "member",
+	EntityTypePublication:     "publication",
+	EntityTypeResearchLine:    "research_line",
+	EntityTypeFinancedProject: "financed_project",
+	EntityTypeArticle:         "article",
+	EntityTypePartner:         "partner",
+	EntityTypeStudentWork:     "student_work",
+	EntityTypeFundingBody:     "funding_body",
+	EntityTypeNewspaper:       "newspaper",
+	EntityTypeResearchArea:    "research_area",
+}
+
+// Attachment links a resource to an entity, such as the final report of a financed project.
+// Attachments of an entity are ordered by Position, starting at 1.
+type Attachment struct {
+	Id         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Resource   int64  `json:"resource"`
+	Label      string `json:"label"`
+	Position   int64  `json:"position"`
+	CreatedBy  string `json:"created_by"`
+	UpdatedBy  string `json:"updated_by"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+// AttachmentAdd attaches the resource to an entity with a label, after its other attachments
+func (dbp *DBProvider) AttachmentAdd(entityType string, id, resourceId int64, label, createdBy string) (attachmentId int64, verr ValidationErrors, err error) {
+	verr = AttachmentValidate(entityType, label)
+	if verr != nil {
+		return
+	}
+	exists, err := dbp.rowExists(attachmentTables[entityType], id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		verr = ValidationErrors{{entityType, "not exist", ValidationCodeNotFound}}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var position int64
+	err = tx.QueryRow("SELECT COALESCE(MAX(position),0)+1 FROM attachment WHERE entity_type=? AND entity_id=? FOR UPDATE", entityType, id).Scan(&position)
+	if err != nil {
+		return
+	}
+	ts := time.Now().Unix()
+	result, err := tx.Exec("INSERT INTO attachment(entity_type,entity_id,resource,label,position,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?)", entityType, id, resourceId, label, position, createdBy, createdBy, ts, ts)
+	if err != nil {
+		if IsDbError1062(err) {
+			tx.Rollback()
+			verr = ValidationErrors{{"resource", "this resource has already been attached", ValidationCodeDuplicate}}
+			err = nil
+			return
+		}
+		if is, field := IsDbError1452(err); is {
+			tx.Rollback()
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
+			err = nil
+			return
+		}
+		return
+	}
+	attachmentId, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) AttachmentUpdateLabel(attachmentId int64, label, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr.add(attachmentValidateLabel(label))
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE attachment SET label=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(label, updatedBy, ts, attachmentId)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// AttachmentRemove detaches a resource from its entity. The resource itself is kept.
+func (dbp *DBProvider) AttachmentRemove(attachmentId int64) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM attachment WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(attachmentId)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+// AttachmentReorder orders the attachments of an entity as attachmentIds, which must hold all of them
+func (dbp *DBProvider) AttachmentReorder(entityType string, id int64, attachmentIds []int64, updatedBy string) (verr ValidationErrors, err error) {
+	attachments, err := dbp.AttachmentGetByEntity(entityType, id)
+	if err != nil {
+		return
+	}
+	current := make(map[int64]bool)
+	for _, attachment := range attachments {
+		current[attachment.Id] = true
+	}
+	seen := make(map[int64]bool)
+	for _, attachmentId := range attachmentIds {
+		if !current[attachmentId] || seen[attachmentId] {
+			verr = ValidationErrors{{"attachments", fmt.Sprintf("attachment %d is not attached to the %s or is repeated", attachmentId, entityType), ValidationCodeInvalidValue}}
+			return
+		}
+		seen[attachmentId] = true
+	}
+	if len(attachmentIds) != len(attachments) {
+		verr = ValidationErrors{{"attachments", "must list every attachment of the " + entityType, ValidationCodeConflict}}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	ts := time.Now().Unix()
+	for i, attachmentId := range attachmentIds {
+		_, err = txExec(tx, "UPDATE attachment SET position=?,updated_by=?,updated_at=? WHERE id=?", i+1, updatedBy, ts, attachmentId)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) AttachmentGetById(attachmentId int64) (attachment *Attachment, err error) {
+	attachment = &Attachment{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM attachment WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(attachmentId).Scan(&attachment.Id, &attachment.EntityType, &attachment.EntityId, &attachment.Resource, &attachment.Label, &attachment.Position, &attachment.CreatedBy, &attachment.UpdatedBy, &attachment.CreatedAt, &attachment.UpdatedAt)
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) AttachmentGetByEntity(entityType string, id int64) (attachments []*Attachment, err error) {
+	attachments, err = dbp.attachmentQuery("SELECT * FROM attachment WHERE entity_type=? AND entity_id=? ORDER BY position ASC,id ASC", entityType, id)
+	return
+}
+func (dbp *DBProvider) AttachmentGetByResource(resourceId int64) (attachments []*Attachment, err error) {
+	attachments, err = dbp.attachmentQuery("SELECT * FROM attachment WHERE resource=? ORDER BY entity_type ASC,entity_id ASC", resourceId)
+	return
+}
+func (dbp *DBProvider) attachmentQuery(query string, args ...interface{}) (attachments []*Attachment, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Attachment{}
+		err = rows.Scan(&p.Id, &p.EntityType, &p.EntityId, &p.Resource, &p.Label, &p.Position, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		attachments = append(attachments, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+func (dbp *DBProvider) MemberGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeMember, id)
+	return
+}
+func (dbp *DBProvider) PublicationGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypePublication, id)
+	return
+}
+func (dbp *DBProvider) ResearchLineGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeResearchLine, id)
+	return
+}
+func (dbp *DBProvider) FinancedProjectGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeFinancedProject, id)
+	return
+}
+func (dbp *DBProvider) ArticleGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeArticle, id)
+	return
+}
+func (dbp *DBProvider) PartnerGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypePartner, id)
+	return
+}
+func (dbp *DBProvider) StudentWorkGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeStudentWork, id)
+	return
+}
+func (dbp *DBProvider) FundingBodyGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeFundingBody, id)
+	return
+}
+func (dbp *DBProvider) NewspaperGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeNewspaper, id)
+	return
+}
+func (dbp *DBProvider) ResearchAreaGetAttachments(id int64) (resources []*Resource, err error) {
+	resources, err = dbp.ResourceGetByAttachment(EntityTypeResearchArea, id)
+	return
+}
+
+// ResourceGetByAttachment returns the resources attached to an entity in order, with the attachment in the Rel fields
+func (dbp *DBProvider) ResourceGetByAttachment(entityType string, id int64) (resources []*Resource, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT resource.*,attachment.id,attachment.label,attachment.position,attachment.created_by,attachment.created_at FROM attachment INNER JOIN resource ON attachment.resource=resource.id WHERE attachment.entity_type=? AND attachment.entity_id=? ORDER BY attachment.position ASC,attachment.id ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(entityType, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Resource{}
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum), &p.RelAttachmentId, &p.RelAttachmentLabel, &p.RelAttachmentPosition, &p.RelAttachmentCreatedBy, &p.RelAttachmentCreatedAt)
+		if err != nil {
+			return
+		}
+		resources = append(resources, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// attachmentDeleteAll removes the attachments of a deleted entity, which no foreign key removes
+func (dbp *DBProvider) attachmentDeleteAll(entityType string, id int64) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM attachment WHERE entity_type=? AND entity_id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(entityType, id)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// AttachmentMigrateResearchLines copies the resources linked to research lines in research_line_resource,
+// before attachments existed, into attachments without a label, after the existing attachments of each
+// research line in the order they were linked. It is meant to run once after upgrading; the old table is left
+// untouched and resources already attached are skipped, so running it again is harmless.
+func (dbp *DBProvider) AttachmentMigrateResearchLines() (migrated int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	type link struct {
+		researchLine, resource, createdAt int64
+		createdBy                         string
+	}
+	var links []*link
+	rows, err := tx.Query("SELECT old.research_line,old.resource,old.created_by,old.created_at FROM research_line_resource AS old WHERE NOT EXISTS(SELECT id FROM attachment WHERE entity_type=? AND entity_id=old.research_line AND resource=old.resource) ORDER BY old.research_line ASC,old.created_at ASC,old.resource ASC", EntityTypeResearchLine)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		p := link{}
+		if err = rows.Scan(&p.researchLine, &p.resource, &p.createdBy, &p.createdAt); err != nil {
+			rows.Close()
+			return
+		}
+		links = append(links, &p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+	ts := time.Now().Unix()
+	positions := make(map[int64]int64)
+	for _, l := range links {
+		position, ok := positions[l.researchLine]
+		if !ok {
+			err = tx.QueryRow("SELECT COALESCE(MAX(position),0) FROM attachment WHERE entity_type=? AND entity_id=?", EntityTypeResearchLine, l.researchLine).Scan(&position)
+			if err != nil {
+				return
+			}
+		}
+		position++
+		positions[l.researchLine] = position
+		_, err = txExec(tx, "INSERT INTO attachment(entity_type,entity_id,resource,label,position,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?)", EntityTypeResearchLine, l.researchLine, l.resource, "", position, l.createdBy, l.createdBy, l.createdAt, ts)
+		if err != nil {
+			return
+		}
+		migrated++
+	}
+	err = tx.Commit()
+	return
+}
+
+// deletePreviewAttachments adds the attachments of the entity to a delete preview
+func (dbp *DBProvider) deletePreviewAttachments(preview *DeletePreview, entityType string, id int64) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	ids, err := queryIds(db, "SELECT resource FROM attachment WHERE entity_type=? AND entity_id=? ORDER BY resource ASC", entityType, id)
+	if err != nil {
+		return
+	}
+	if ids == nil {
+		ids = []int64{}
+	}
+	preview.Relations = append(preview.Relations, &DeletePreviewRelation{"attachments", "attachment", DeletePreviewDetached, int64(len(ids)), ids})
+	return
+}
+
+// mergeAttachmentRelation moves the attachments of dropId after the ones of keepId, skipping the resources keepId already has
+func mergeAttachmentRelation(tx *sql.Tx, entityType string, keepId, dropId int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: "attachments", Table: "attachment"}
+	query := "DELETE FROM attachment WHERE entity_type=? AND entity_id=? AND resource IN (SELECT resource FROM (SELECT resource FROM attachment WHERE entity_type=? AND entity_id=?) AS kept)"
+	rel.Skipped, err = txExec(tx, query, entityType, dropId, entityType, keepId)
+	if err != nil {
+		return
+	}
+	var last int64
+	err = tx.QueryRow("SELECT COALESCE(MAX(position),0) FROM attachment WHERE entity_type=? AND entity_id=?", entityType, keepId).Scan(&last)
+	if err != nil {
+		return
+	}
+	rel.Moved, err = txExec(tx, "UPDATE attachment SET entity_id=?,position=position+? WHERE entity_type=? AND entity_id=?", keepId, last, entityType, dropId)
+	if err != nil {
+		return
+	}
+	return
+}
+
+func (dbp *DBProvider) AttachmentGetColumns() []string {
+	return []string{"id", "entity_type", "entity_id", "resource", "label", "position", "created_by", "updated_by", "created_at", "updated_at"}
+}
+
+func attachmentValidateEntityType(entityType string) (verr *ValidationError) {
+	if _, ok := attachmentTables[entityType]; !ok {
+		return &ValidationError{"entity_type", "cannot have attachments", ValidationCodeInvalidValue}
+	}
+	return nil
+}
+func attachmentValidateLabel(label string) (verr *ValidationError) {
+	return validateLength("label", label, 200)
+}
+func AttachmentValidate(entityType, label string) (verrs ValidationErrors) {
+	verrs.add(attachmentValidateEntityType(entityType))
+	verrs.add(attachmentValidateLabel(label))
+	return
+}
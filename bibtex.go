@@ -0,0 +1,651 @@
+package instantolib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BibtexEntry is an entry of a BibTeX file. Type and field names are lowercase and field values
+// are kept as written, with their braces and LaTeX commands; Field and Names decode them.
+type BibtexEntry struct {
+	Type   string            `json:"type"`
+	Key    string            `json:"key"`
+	Fields map[string]string `json:"fields"`
+	Line   int               `json:"line"`
+	// syntaxErr is why the entry could not be parsed, for the entries parseBibtex recovered from
+	syntaxErr error
+}
+
+// BibtexName is a name of an author or editor list. Last includes the von and Jr parts.
+type BibtexName struct {
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// BibtexSyntaxError is returned by ParseBibtex for malformed input
+type BibtexSyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (err *BibtexSyntaxError) Error() string {
+	return fmt.Sprintf("bibtex: line %d: %s", err.Line, err.Msg)
+}
+
+// bibtexMonths are the month macros predefined by BibTeX
+var bibtexMonths = map[string]string{
+	"jan": "January", "feb": "February", "mar": "March", "apr": "April", "may": "May", "jun": "June",
+	"jul": "July", "aug": "August", "sep": "September", "oct": "October", "nov": "November", "dec": "December",
+}
+
+// ParseBibtex reads the entries of a BibTeX file. @string macros are expanded, @comment and @preamble
+// are skipped and text outside entries is ignored.
+func ParseBibtex(r io.Reader) (entries []*BibtexEntry, err error) {
+	entries, err = parseBibtex(r, false)
+	return
+}
+
+// parseBibtex is ParseBibtex. If lenient, an entry with a syntax error is returned with syntaxErr set,
+// holding what could be read of it, and parsing goes on at the next @ after the one starting it.
+func parseBibtex(r io.Reader, lenient bool) (entries []*BibtexEntry, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	p := &bibtexParser{src: string(data), line: 1, macros: make(map[string]string)}
+	for k, v := range bibtexMonths {
+		p.macros[k] = v
+	}
+	for {
+		if !p.skipTo('@') {
+			return
+		}
+		start, line := p.pos, p.line
+		entry, parseErr := p.parseEntry()
+		if parseErr != nil {
+			if !lenient {
+				err = parseErr
+				return
+			}
+			if entry == nil {
+				entry = &BibtexEntry{Fields: make(map[string]string), Line: line}
+			}
+			entry.syntaxErr = parseErr
+			p.pos, p.line = start, line
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+type bibtexParser struct {
+	src    string
+	pos    int
+	line   int
+	macros map[string]string
+}
+
+func (p *bibtexParser) errorf(format string, args ...interface{}) error {
+	return &BibtexSyntaxError{p.line, fmt.Sprintf(format, args...)}
+}
+
+func (p *bibtexParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *bibtexParser) skipTo(c byte) bool {
+	for p.pos < len(p.src) {
+		if p.next() == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *bibtexParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.next()
+	}
+}
+
+// peek returns the next byte that is not a space, or 0 at the end of the input
+func (p *bibtexParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *bibtexParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.next()
+	return nil
+}
+
+// identifier reads a type, key, field or macro name
+func (p *bibtexParser) identifier() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n{}()\",=#%", p.src[p.pos]) < 0 {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+// parseEntry parses what follows an @. It returns a nil entry for @string, @comment and @preamble,
+// and on a syntax error what it could read of the entry.
+func (p *bibtexParser) parseEntry() (entry *BibtexEntry, err error) {
+	line := p.line
+	entryType := strings.ToLower(p.identifier())
+	if entryType == "" {
+		return nil, p.errorf("missing entry type after @")
+	}
+	open := p.peek()
+	if open != '{' && open != '(' {
+		// a lone @ in the text between entries
+		if entryType == "comment" {
+			return nil, nil
+		}
+		return nil, p.errorf("expected { after @%s", entryType)
+	}
+	closing := byte('}')
+	if open == '(' {
+		closing = ')'
+	}
+	switch entryType {
+	case "comment":
+		if open == '{' {
+			_, err = p.braced()
+			return
+		}
+		err = p.parenthesized()
+		return
+	case "preamble":
+		p.next()
+		if _, err = p.value(); err != nil {
+			return
+		}
+		err = p.expect(closing)
+		return
+	case "string":
+		p.next()
+		var name, value string
+		name, value, err = p.field()
+		if err != nil {
+			return
+		}
+		p.macros[name] = value
+		err = p.expect(closing)
+		return
+	}
+	p.next()
+	entry = &BibtexEntry{Type: entryType, Fields: make(map[string]string), Line: line}
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ',' && p.src[p.pos] != closing {
+		p.next()
+	}
+	entry.Key = strings.TrimSpace(p.src[start:p.pos])
+	for {
+		switch p.peek() {
+		case 0:
+			return entry, p.errorf("unterminated entry %s", entry.Key)
+		case closing:
+			p.next()
+			return
+		case ',':
+			p.next()
+			if p.peek() == closing {
+				continue
+			}
+			var name, value string
+			name, value, err = p.field()
+			if err != nil {
+				return entry, err
+			}
+			entry.Fields[name] = value
+		default:
+			return entry, p.errorf("expected , or %q in entry %s", closing, entry.Key)
+		}
+	}
+}
+
+// field parses name = value
+func (p *bibtexParser) field() (name, value string, err error) {
+	name = strings.ToLower(p.identifier())
+	if name == "" {
+		err = p.errorf("missing field name")
+		return
+	}
+	if err = p.expect('='); err != nil {
+		return
+	}
+	value, err = p.value()
+	return
+}
+
+// value parses a field value, concatenating the parts joined by #
+func (p *bibtexParser) value() (value string, err error) {
+	for {
+		var part string
+		switch c := p.peek(); {
+		case c == '{':
+			part, err = p.braced()
+		case c == '"':
+			part, err = p.quoted()
+		case c >= '0' && c <= '9':
+			part = p.identifier()
+		case c == 0:
+			err = p.errorf("missing field value")
+		default:
+			name := p.identifier()
+			if name == "" {
+				err = p.errorf("unexpected %q", c)
+				break
+			}
+			var ok bool
+			if part, ok = p.macros[strings.ToLower(name)]; !ok {
+				part = name
+			}
+		}
+		if err != nil {
+			return
+		}
+		value += part
+		if p.peek() != '#' {
+			return
+		}
+		p.next()
+	}
+}
+
+// braced returns the text between balanced braces, without the outer ones
+func (p *bibtexParser) braced() (text string, err error) {
+	line := p.line
+	p.next()
+	start, depth := p.pos, 1
+	for p.pos < len(p.src) {
+		switch p.next() {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+	return "", &BibtexSyntaxError{line, "unbalanced braces"}
+}
+
+// parenthesized skips the text up to the ) closing the ( at the current position, ignoring the ones in braces
+func (p *bibtexParser) parenthesized() error {
+	line := p.line
+	p.next()
+	depth := 0
+	for p.pos < len(p.src) {
+		switch p.next() {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ')':
+			if depth <= 0 {
+				return nil
+			}
+		}
+	}
+	return &BibtexSyntaxError{line, "unterminated @comment"}
+}
+
+// quoted returns the text between double quotes, which may contain braced quotes
+func (p *bibtexParser) quoted() (text string, err error) {
+	line := p.line
+	p.next()
+	start, depth := p.pos, 0
+	for p.pos < len(p.src) {
+		switch p.next() {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"':
+			if depth == 0 {
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+	return "", &BibtexSyntaxError{line, "unterminated quoted value"}
+}
+
+// Field returns the value of a field as plain text, or "" if the entry does not have it
+func (entry *BibtexEntry) Field(name string) string {
+	return DecodeLatex(entry.Fields[name])
+}
+
+// Names returns the names of a name list field such as author or editor. "others" is left out.
+func (entry *BibtexEntry) Names(field string) (names []BibtexName) {
+	value := strings.Join(strings.Fields(entry.Fields[field]), " ")
+	for _, raw := range splitBibtexTopLevel(value, func(s string, i int) int {
+		if strings.HasPrefix(strings.ToLower(s[i:]), " and ") {
+			return 5
+		}
+		return 0
+	}) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || raw == "others" {
+			continue
+		}
+		names = append(names, parseBibtexName(raw))
+	}
+	return
+}
+
+// parseBibtexName splits "First von Last", "von Last, First" or "von Last, Jr, First"
+func parseBibtexName(raw string) BibtexName {
+	parts := splitBibtexTopLevel(raw, func(s string, i int) int {
+		if s[i] == ',' {
+			return 1
+		}
+		return 0
+	})
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) == 2 {
+		return BibtexName{DecodeLatex(parts[1]), DecodeLatex(parts[0])}
+	}
+	if len(parts) > 2 {
+		return BibtexName{DecodeLatex(parts[2]), DecodeLatex(parts[0] + " " + parts[1])}
+	}
+	words := splitBibtexTopLevel(raw, func(s string, i int) int {
+		if s[i] == ' ' || s[i] == '\t' || s[i] == '\n' {
+			return 1
+		}
+		return 0
+	})
+	var tokens []string
+	for _, w := range words {
+		if w != "" {
+			tokens = append(tokens, w)
+		}
+	}
+	if len(tokens) == 0 {
+		return BibtexName{}
+	}
+	// the last name starts at the first lowercase (von) word, and is at least the last word
+	split := len(tokens) - 1
+	for i := 0; i < len(tokens)-1; i++ {
+		if r, _ := utf8.DecodeRuneInString(tokens[i]); unicode.IsLower(r) {
+			split = i
+			break
+		}
+	}
+	return BibtexName{DecodeLatex(strings.Join(tokens[:split], " ")), DecodeLatex(strings.Join(tokens[split:], " "))}
+}
+
+// splitBibtexTopLevel splits s at the separators outside braces. sep returns the length
+// of the separator starting at s[i], or 0 if there is none.
+func splitBibtexTopLevel(s string, sep func(s string, i int) int) (parts []string) {
+	start, depth := 0, 0
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 {
+			if n := sep(s, i); n > 0 {
+				parts = append(parts, s[start:i])
+				i += n
+				start = i
+				continue
+			}
+		}
+		i++
+	}
+	return append(parts, s[start:])
+}
+
+// latexAccents maps the LaTeX accent commands to pairs of base and accented letters
+var latexAccents = map[string]string{
+	"'":  "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ",
+	"`":  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	"^":  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	"\"": "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+	"~":  "aãnñoõAÃNÑOÕ",
+	"c":  "cçsşCÇSŞ",
+	"v":  "cčsšzžrřeěnňCČSŠZŽRŘEĚNŇ",
+	"=":  "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+	"H":  "oőuűOŐUŰ",
+	"k":  "aąeęAĄEĘ",
+	".":  "zżZŻ",
+	"r":  "aåuůAÅUŮ",
+	"u":  "aăgğuŭAĂGĞUŬ",
+}
+
+// latexSymbols are the LaTeX commands for letters and escaped characters
+var latexSymbols = map[string]string{
+	"ss": "ß", "o": "ø", "O": "Ø", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ", "aa": "å", "AA": "Å",
+	"l": "ł", "L": "Ł", "i": "ı", "j": "ȷ", "TeX": "TeX", "LaTeX": "LaTeX",
+	"textasciitilde": "~", "textasciicircum": "^", "textbackslash": "\\",
+	"&": "&", "%": "%", "$": "$", "_": "_", "#": "#", "{": "{", "}": "}", " ": " ", "-": "",
+}
+
+// DecodeLatex turns the value of a BibTeX field into plain text: LaTeX accents and escapes are replaced
+// by the characters they stand for, dashes are converted, braces are removed and spaces collapsed.
+func DecodeLatex(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '{' || c == '}':
+			i++
+		case c == '~':
+			b.WriteByte(' ')
+			i++
+		case c == '\\' && i+1 < len(s):
+			text, n := decodeLatexCommand(s[i+1:])
+			b.WriteString(text)
+			i += 1 + n
+		case strings.HasPrefix(s[i:], "---"):
+			b.WriteString("—")
+			i += 3
+		case strings.HasPrefix(s[i:], "--"):
+			b.WriteString("–")
+			i += 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// decodeLatexCommand decodes the command at the start of s, which follows a backslash.
+// It returns the text and the number of bytes of s consumed.
+func decodeLatexCommand(s string) (text string, n int) {
+	name := s[:1]
+	if isLatexLetter(s[0]) {
+		for n = 1; n < len(s) && isLatexLetter(s[n]); n++ {
+		}
+		name = s[:n]
+	} else {
+		n = 1
+	}
+	if pairs, ok := latexAccents[name]; ok {
+		arg, m := latexArgument(s[n:], !isLatexLetter(name[0]))
+		// accents go on the dotless \i and \j
+		if arg == "\\i" || arg == "\\j" {
+			arg = arg[1:]
+		}
+		return accentLetter(pairs, arg), n + m
+	}
+	if symbol, ok := latexSymbols[name]; ok {
+		// the space that ends a command name is not part of the text
+		if isLatexLetter(name[0]) && n < len(s) && s[n] == ' ' {
+			n++
+		}
+		return symbol, n
+	}
+	// other commands, such as \emph or \textit, only keep their argument
+	if isLatexLetter(name[0]) {
+		for n < len(s) && s[n] == ' ' {
+			n++
+		}
+		return "", n
+	}
+	return name, n
+}
+
+// latexArgument returns the argument of an accent command: a braced group, a command such as \i,
+// or a single letter, which may follow the command name with no space when symbolic is set.
+func latexArgument(s string, symbolic bool) (arg string, n int) {
+	if !symbolic || (len(s) > 0 && s[0] == ' ') {
+		for n < len(s) && s[n] == ' ' {
+			n++
+		}
+	}
+	if n >= len(s) {
+		return "", n
+	}
+	if s[n] == '{' {
+		end := strings.IndexByte(s[n:], '}')
+		if end < 0 {
+			return s[n+1:], len(s)
+		}
+		return strings.TrimSpace(s[n+1 : n+end]), n + end + 1
+	}
+	if s[n] == '\\' {
+		m := n + 1
+		for m < len(s) && isLatexLetter(s[m]) {
+			m++
+		}
+		return s[n:m], m
+	}
+	_, size := utf8.DecodeRuneInString(s[n:])
+	return s[n : n+size], n + size
+}
+
+// accentLetter returns the accented form of letter found in pairs, or letter itself
+func accentLetter(pairs, letter string) string {
+	runes := []rune(pairs)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if string(runes[i]) == letter {
+			return string(runes[i+1])
+		}
+	}
+	return letter
+}
+
+func isLatexLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// bibtexFieldOrder is the order WriteBibtex writes the fields in; other fields follow in alphabetical order
+var bibtexFieldOrder = []string{
+	"author", "editor", "title", "booktitle", "eventtitle", "chapter", "journal", "year", "volume", "number",
+	"series", "edition", "pages", "publisher", "organization", "institution", "school", "address", "language",
+	"isbn", "issn", "doi",
+}
+
+// WriteBibtex writes entries in BibTeX format. Field values are written as they are, so they must be
+// valid LaTeX with balanced braces, such as the ones made by EncodeLatex.
+func WriteBibtex(w io.Writer, entries []*BibtexEntry) error {
+	bw := bufio.NewWriter(w)
+	for i, entry := range entries {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "@%s{%s", entry.Type, entry.Key)
+		for _, name := range bibtexFieldNames(entry) {
+			fmt.Fprintf(bw, ",\n  %s = {%s}", name, entry.Fields[name])
+		}
+		bw.WriteString("\n}\n")
+	}
+	return bw.Flush()
+}
+
+// bibtexFieldNames returns the names of the non empty fields of entry in bibtexFieldOrder
+func bibtexFieldNames(entry *BibtexEntry) (names []string) {
+	known := make(map[string]bool)
+	for _, name := range bibtexFieldOrder {
+		known[name] = true
+		if entry.Fields[name] != "" {
+			names = append(names, name)
+		}
+	}
+	var others []string
+	for name, value := range entry.Fields {
+		if !known[name] && value != "" {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// latexEscapes are the LaTeX forms of the characters that are special to BibTeX or LaTeX
+var latexEscapes = map[rune]string{
+	'&': "\\&", '%': "\\%", '$': "\\$", '#': "\\#", '_': "\\_", '{': "\\{", '}': "\\}",
+	'~': "\\textasciitilde{}", '^': "\\textasciicircum{}", '\\': "\\textbackslash{}",
+	'–': "{--}", '—': "{---}",
+}
+
+// latexLetters maps accented and special letters to their LaTeX commands
+var latexLetters = func() map[rune]string {
+	letters := make(map[rune]string)
+	for command, pairs := range latexAccents {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			if isLatexLetter(command[0]) {
+				letters[runes[i+1]] = "{\\" + command + "{" + string(runes[i]) + "}}"
+			} else {
+				letters[runes[i+1]] = "{\\" + command + string(runes[i]) + "}"
+			}
+		}
+	}
+	for command, symbol := range latexSymbols {
+		if r := []rune(symbol); len(r) == 1 && r[0] > unicode.MaxASCII && letters[r[0]] == "" {
+			letters[r[0]] = "{\\" + command + "}"
+		}
+	}
+	return letters
+}()
+
+// EncodeLatex turns plain text into a BibTeX field value: special characters are escaped and
+// accented letters are written as LaTeX accent commands. DecodeLatex turns it back into s.
+func EncodeLatex(s string) string {
+	var b bytes.Buffer
+	runes := []rune(s)
+	for i, r := range runes {
+		if r == '-' && i+1 < len(runes) && runes[i+1] == '-' {
+			// keep hyphens from becoming a dash
+			b.WriteString("-{}")
+		} else if escaped, ok := latexEscapes[r]; ok {
+			b.WriteString(escaped)
+		} else if letter, ok := latexLetters[r]; ok {
+			b.WriteString(letter)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package instantolib
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoBlobStore is returned when resource contents are used before calling SetBlobStore
+	ErrNoBlobStore = errors.New("no blob store configured")
+	// ErrBlobNotFound is returned when a blob or the blob of a resource does not exist
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrBlobListUnsupported is returned by BlobCollectGarbage when the blob store is not a BlobLister
+	ErrBlobListUnsupported = errors.New("the blob store cannot list its blobs")
+)
+
+// BlobStore stores the contents of resources and images by key.
+// Keys are generated by the library and only contain lowercase hexadecimal characters.
+type BlobStore interface {
+	// Put stores the contents of r under key and returns the number of bytes stored
+	Put(key string, r io.Reader) (size int64, err error)
+	// Open returns the contents stored under key, or ErrBlobNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the contents stored under key, a missing key is not an error
+	Delete(key string) error
+}
+
+// BlobLister is implemented by the blob stores that can list their keys, which BlobCollectGarbage needs
+type BlobLister interface {
+	// ListBlobs returns the keys of the blobs stored before the time
+	ListBlobs(before time.Time) ([]string, error)
+}
+
+// LocalBlobStore is a BlobStore keeping every blob as a file under a root directory
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore returns a LocalBlobStore rooted at root, creating the directory if needed
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{root}, nil
+}
+
+// path spreads the blobs in subdirectories named after the first characters of the key
+func (store *LocalBlobStore) path(key string) (string, error) {
+	if len(key) < 4 || strings.Trim(key, "0123456789abcdef") != "" {
+		return "", errors.New("invalid blob key " + key)
+	}
+	return filepath.Join(store.root, key[:2], key[2:4], key), nil
+}
+
+// Path returns the file holding the blob stored under key, for serving it directly
+func (store *LocalBlobStore) Path(key string) (string, error) {
+	return store.path(key)
+}
+
+// Put writes to a temporary file first, so a failed upload never leaves a partial blob under key
+func (store *LocalBlobStore) Put(key string, r io.Reader) (size int64, err error) {
+	path, err := store.path(key)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	size, err = io.Copy(tmp, r)
+	if err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), path)
+	return
+}
+func (store *LocalBlobStore) Open(key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (store *LocalBlobStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+func (store *LocalBlobStore) ListBlobs(before time.Time) (keys []string, err error) {
+	err = filepath.Walk(store.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// temporary files of uploads in progress start with a dot
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || !info.ModTime().Before(before) {
+			return nil
+		}
+		keys = append(keys, info.Name())
+		return nil
+	})
+	return
+}
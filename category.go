@@ -15,8 +15,8 @@ type Category struct {
 	UpdatedAt   int64  `json:"updated_at"`
 }
 
-func (dbp *DBProvider) CategoryCreate(name, description, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = categoryValidate(name, description)
+func (dbp *DBProvider) CategoryCreate(name, description, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = CategoryValidate(name, description)
 	if verr != nil {
 		return
 	}
@@ -35,7 +35,7 @@ func (dbp *DBProvider) CategoryCreate(name, description, createdBy string) (id i
 	result, err := stmt.Exec(name, description, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -47,8 +47,8 @@ func (dbp *DBProvider) CategoryCreate(name, description, createdBy string) (id i
 	}
 	return
 }
-func (dbp *DBProvider) CategoryUpdate(id int64, name, description, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = categoryValidate(name, description)
+func (dbp *DBProvider) CategoryUpdate(id int64, name, description, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = CategoryValidate(name, description)
 	if verr != nil {
 		return
 	}
@@ -67,7 +67,7 @@ func (dbp *DBProvider) CategoryUpdate(id int64, name, description, updatedBy str
 	result, err := stmt.Exec(name, description, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -99,9 +99,12 @@ func (dbp *DBProvider) CategoryDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.translationDeleteAll(EntityTypeCategory, id)
+	}
 	return
 }
-func (dbp *DBProvider) CategoryGetAll() (categorys []*Category, err error) {
+func (dbp *DBProvider) CategoryGetAll(opts ...QueryOption) (categorys []*Category, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -130,9 +133,14 @@ func (dbp *DBProvider) CategoryGetAll() (categorys []*Category, err error) {
 	if err != nil {
 		return
 	}
+	items := make([]translatable, len(categorys))
+	for i, p := range categorys {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypeCategory, items, opts)
 	return
 }
-func (dbp *DBProvider) CategoryGetById(id int64) (category *Category, err error) {
+func (dbp *DBProvider) CategoryGetById(id int64, opts ...QueryOption) (category *Category, err error) {
 	category = &Category{}
 	db, err := dbp.getDB()
 	if err != nil {
@@ -149,6 +157,7 @@ func (dbp *DBProvider) CategoryGetById(id int64) (category *Category, err error)
 	if err != nil {
 		return
 	}
+	err = dbp.translate(EntityTypeCategory, []translatable{category}, opts)
 	return
 }
 func (dbp *DBProvider) CategoryCount() (count int64, err error) {
@@ -206,29 +215,23 @@ func (dbp *DBProvider) CategoryGetColumns() []string {
 }
 func categoryValidateName(name string) (verr *ValidationError) {
 	if len(name) == 0 {
-		verr = &ValidationError{"name", "cannot be empty"}
+		verr = &ValidationError{"name", "cannot be empty", ValidationCodeRequired}
 		return
 	}
 	if len(name) > 45 {
-		verr = &ValidationError{"name", "length cannot be greater than 45"}
+		verr = &ValidationError{"name", "length cannot be greater than 45", ValidationCodeTooLong}
 		return
 	}
 	return
 }
 func categoryValidateDescription(description string) (verr *ValidationError) {
 	if len(description) > 45 {
-		verr = &ValidationError{"description", "length cannot be greater than 45"}
+		verr = &ValidationError{"description", "length cannot be greater than 45", ValidationCodeTooLong}
 	}
 	return
 }
-func categoryValidate(name, description string) (verr *ValidationError) {
-	verr = categoryValidateName(name)
-	if verr != nil {
-		return
-	}
-	verr = categoryValidateDescription(description)
-	if verr != nil {
-		return
-	}
+func CategoryValidate(name, description string) (verrs ValidationErrors) {
+	verrs.add(categoryValidateName(name))
+	verrs.add(categoryValidateDescription(description))
 	return
 }
@@ -0,0 +1,190 @@
+package instantolib
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// CategoryTag is a category put on an entity of any of the entity types
+type CategoryTag struct {
+	Id         int64  `json:"id"`
+	Category   int64  `json:"category"`
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	CreatedBy  string `json:"created_by"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// CategoryTag tags the entity of entityType with id with the category categoryId
+func (dbp *DBProvider) CategoryTag(entityType string, id, categoryId int64, createdBy string) (verr *ValidationError, err error) {
+	if verr = validateEntityType("entity_type", entityType); verr != nil {
+		return
+	}
+	exists, err := dbp.entityExists(entityType, id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		verr = &ValidationError{entityType, "not exist", ValidationCodeNotFound}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO category_tag(category,entity_type,entity_id,created_by,created_at) VALUES(?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	_, err = stmt.Exec(categoryId, entityType, id, createdBy, ts)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = &ValidationError{"category", "this category has already been added", ValidationCodeDuplicate}
+			err = nil
+			return
+		}
+		if is, field := IsDbError1452(err); is {
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
+			err = nil
+			return
+		}
+		return
+	}
+	return
+}
+func (dbp *DBProvider) CategoryUntag(entityType string, id, categoryId int64) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM category_tag WHERE category=? AND entity_type=? AND entity_id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(categoryId, entityType, id)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+// categoryUntagAll removes the tags of a deleted entity, which no foreign key removes
+func (dbp *DBProvider) categoryUntagAll(entityType string, id int64) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM category_tag WHERE entity_type=? AND entity_id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(entityType, id)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// CategoryGetTagged returns every tag of the category, grouped by entity type
+func (dbp *DBProvider) CategoryGetTagged(categoryId int64) (tags []*CategoryTag, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM category_tag WHERE category=? ORDER BY entity_type ASC,created_at ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := CategoryTag{}
+		err = rows.Scan(&p.Id, &p.Category, &p.EntityType, &p.EntityId, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		tags = append(tags, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// CategoryGetByEntity returns the categories the entity of entityType with id is tagged with
+func (dbp *DBProvider) CategoryGetByEntity(entityType string, id int64) (categories []*Category, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT category.* FROM category_tag INNER JOIN category ON category_tag.category=category.id WHERE entity_type=? AND entity_id=? ORDER BY category.name ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(entityType, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Category{}
+		err = rows.Scan(&p.Id, &p.Name, &p.Description, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		categories = append(categories, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// mergeTagRelation moves the tags of the entity dropId to keepId, skipping the categories keepId already has
+func mergeTagRelation(tx *sql.Tx, entityType string, keepId, dropId int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: "categories", Table: "category_tag"}
+	query := "DELETE FROM category_tag WHERE entity_type=? AND entity_id=? AND category IN (SELECT category FROM (SELECT category FROM category_tag WHERE entity_type=? AND entity_id=?) AS kept)"
+	rel.Skipped, err = txExec(tx, query, entityType, dropId, entityType, keepId)
+	if err != nil {
+		return
+	}
+	rel.Moved, err = txExec(tx, "UPDATE category_tag SET entity_id=? WHERE entity_type=? AND entity_id=?", keepId, entityType, dropId)
+	if err != nil {
+		return
+	}
+	return
+}
+
+func (dbp *DBProvider) CategoryTagGetColumns() []string {
+	return []string{"id", "category", "entity_type", "entity_id", "created_by", "created_at"}
+}
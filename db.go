@@ -4,14 +4,44 @@ import (
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
 	"strings"
+	"sync"
 )
 
 func NewDBProvider(dsn string) (*DBProvider, error) {
-	return &DBProvider{dsn}, nil
+	return &DBProvider{dsn: dsn}, nil
 }
 
 type DBProvider struct {
-	dsn string
+	dsn        string
+	blobs      BlobStore
+	signingKey []byte
+	// blobLock is held for reading from storing contents until they are referenced,
+	// and for writing while checking contents are unreferenced and removing them
+	blobLock sync.RWMutex
+	// imageBaseURL prefixes the URLs of image variants
+	imageBaseURL string
+	// resourceBaseURL prefixes the URLs of resources used as assets
+	resourceBaseURL string
+}
+
+// SetBlobStore sets where the contents of resources are stored
+func (dbp *DBProvider) SetBlobStore(blobs BlobStore) {
+	dbp.blobs = blobs
+}
+
+// SetImageBaseURL sets the URL where the blobs of image variants are served from
+func (dbp *DBProvider) SetImageBaseURL(baseURL string) {
+	dbp.imageBaseURL = baseURL
+}
+
+// SetResourceBaseURL sets the URL where resources are downloaded from, see ResourceURL
+func (dbp *DBProvider) SetResourceBaseURL(baseURL string) {
+	dbp.resourceBaseURL = baseURL
+}
+
+// SetSigningKey sets the secret key used to sign links to private resources
+func (dbp *DBProvider) SetSigningKey(key []byte) {
+	dbp.signingKey = key
 }
 
 func (dbp *DBProvider) getDB() (*sql.DB, error) {
@@ -45,3 +75,101 @@ func IsDbError1452(err error) (is bool, field string) {
 	field = errString[indexFK:indexRef]
 	return
 }
+
+// nullString scans a nullable string column, leaving NULL as an empty string
+type nullString string
+
+func (s *nullString) Scan(value interface{}) error {
+	var ns sql.NullString
+	if err := ns.Scan(value); err != nil {
+		return err
+	}
+	*s = nullString(ns.String)
+	return nil
+}
+
+// nullIfEmpty stores an empty string as NULL, so unique columns can be left empty in several rows
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// dbError1062Field returns which of columns is named in the key of a Error 1062: Duplicate entry,
+// or the first of columns if the key does not tell
+// Error 1062: Duplicate entry '0000-0002-1825-0097' for key 'orcid_UNIQUE'
+func dbError1062Field(err error, columns ...string) (field string) {
+	if len(columns) == 0 {
+		return
+	}
+	field = columns[0]
+	errString := err.Error()
+	indexKey := strings.LastIndex(errString, "for key")
+	if indexKey == -1 {
+		return
+	}
+	key := errString[indexKey:]
+	for _, column := range columns {
+		if strings.Contains(key, column) {
+			field = column
+			return
+		}
+	}
+	return
+}
+
+// sqlPlaceholders returns n comma separated placeholders for an IN clause
+func sqlPlaceholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+// nullInt64 scans a nullable reference column, leaving NULL as 0
+type nullInt64 int64
+
+func (n *nullInt64) Scan(value interface{}) error {
+	var ni sql.NullInt64
+	if err := ni.Scan(value); err != nil {
+		return err
+	}
+	*n = nullInt64(ni.Int64)
+	return nil
+}
+
+// nullIfZero stores a 0 reference as NULL
+func nullIfZero(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// queryIds runs a query selecting a single integer column and returns its values
+func queryIds(db *sql.DB, query string, args ...interface{}) (ids []int64, err error) {
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package instantolib
+
+import (
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// DeletePreviewDetached marks rows of a join table that are removed with the entity
+	DeletePreviewDetached = "detached"
+	// DeletePreviewDependent marks rows of other tables that reference the entity
+	DeletePreviewDependent = "dependent"
+)
+
+type DeletePreview struct {
+	Id        int64                    `json:"id"`
+	Relations []*DeletePreviewRelation `json:"relations"`
+}
+
+type DeletePreviewRelation struct {
+	Name  string  `json:"name"`
+	Table string  `json:"table"`
+	Kind  string  `json:"kind"`
+	Count int64   `json:"count"`
+	Ids   []int64 `json:"ids"`
+}
+
+type deletePreviewSpec struct {
+	name   string
+	table  string
+	kind   string
+	column string
+	ref    string
+}
+
+// Total returns the number of rows affected by the delete across all relations
+func (preview *DeletePreview) Total() (total int64) {
+	for _, rel := range preview.Relations {
+		total += rel.Count
+	}
+	return
+}
+
+func (dbp *DBProvider) deletePreview(id int64, specs []deletePreviewSpec) (preview *DeletePreview, err error) {
+	preview = &DeletePreview{Id: id}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	for _, spec := range specs {
+		var ids []int64
+		ids, err = deletePreviewCollect(db, spec, id)
+		if err != nil {
+			return
+		}
+		rel := &DeletePreviewRelation{spec.name, spec.table, spec.kind, int64(len(ids)), ids}
+		preview.Relations = append(preview.Relations, rel)
+	}
+	return
+}
+
+func deletePreviewCollect(db *sql.DB, spec deletePreviewSpec, id int64) (ids []int64, err error) {
+	query := "SELECT " + spec.column + " FROM " + spec.table + " WHERE " + spec.ref + "=? ORDER BY " + spec.column + " ASC"
+	ids, err = queryIds(db, query, id)
+	if ids == nil {
+		ids = []int64{}
+	}
+	return
+}
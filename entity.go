@@ -0,0 +1,76 @@
+package instantolib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Entity types name the entities that polymorphic relations can point to
+const (
+	EntityTypeMember          = "member"
+	EntityTypePublication     = "publication"
+	EntityTypeResearchLine    = "research_line"
+	EntityTypeFinancedProject = "financed_project"
+	EntityTypeArticle         = "article"
+	EntityTypeResource        = "resource"
+)
+
+// entityTables maps every entity type to its table
+var entityTables = map[string]string{
+	EntityTypeMember:          "member",
+	EntityTypePublication:     "publication",
+	EntityTypeResearchLine:    "research_line",
+	EntityTypeFinancedProject: "financed_project",
+	EntityTypeArticle:         "article",
+	EntityTypeResource:        "resource",
+}
+
+// entityExists checks the entity referenced by a polymorphic relation, which the database cannot check with a foreign key
+func (dbp *DBProvider) entityExists(entityType string, id int64) (exists bool, err error) {
+	table, ok := entityTables[entityType]
+	if !ok {
+		return
+	}
+	exists, err = dbp.rowExists(table, id)
+	return
+}
+
+// rowExists checks the row id exists in table, which must never come from the caller
+func (dbp *DBProvider) rowExists(table string, id int64) (exists bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(id) as count FROM " + table + " WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var count int64
+	err = stmt.QueryRow(id).Scan(&count)
+	if err != nil {
+		return
+	}
+	if count != 1 {
+		return
+	}
+	exists = true
+	return
+}
+
+func validateEntityType(field, entityType string) *ValidationError {
+	if _, ok := entityTables[entityType]; ok {
+		return nil
+	}
+	types := make([]string, 0, len(entityTables))
+	for t := range entityTables {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return &ValidationError{field, fmt.Sprintf("must be one of %s", strings.Join(types, ", ")), ValidationCodeInvalidValue}
+}
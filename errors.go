@@ -1,10 +1,52 @@
 package instantolib
 
+import "strings"
+
+const (
+	ValidationCodeRequired      = "required"
+	ValidationCodeTooLong       = "too_long"
+	ValidationCodeTooShort      = "too_short"
+	ValidationCodeInvalidFormat = "invalid_format"
+	ValidationCodeInvalidValue  = "invalid_value"
+	ValidationCodeOutOfRange    = "out_of_range"
+	ValidationCodeNotFound      = "not_found"
+	ValidationCodeDuplicate     = "duplicate"
+	ValidationCodeConflict      = "conflict"
+)
+
 type ValidationError struct {
 	Field  string `json:"field"`
 	Reason string `json:"reason"`
+	Code   string `json:"code"`
 }
 
 func (err *ValidationError) Error() string {
 	return err.Field + ": " + err.Reason
 }
+
+// ValidationErrors holds every failing field of a validation
+type ValidationErrors []*ValidationError
+
+func (verrs ValidationErrors) Error() string {
+	msgs := make([]string, len(verrs))
+	for i, verr := range verrs {
+		msgs[i] = verr.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Field returns the first error of field, or nil if field is valid
+func (verrs ValidationErrors) Field(field string) *ValidationError {
+	for _, verr := range verrs {
+		if verr.Field == field {
+			return verr
+		}
+	}
+	return nil
+}
+
+func (verrs *ValidationErrors) add(verr *ValidationError) {
+	if verr != nil {
+		*verrs = append(*verrs, verr)
+	}
+}
@@ -1,6 +1,10 @@
 package instantolib
 
 import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -20,6 +24,7 @@ type FinancedProject struct {
 	PrimaryFundingBody         int64  `json:"primary_funding_body"`
 	PrimaryRecord              string `json:"primary_record"`
 	PrimaryLeader              int64  `json:"primary_leader"`
+	BudgetCurrency             string `json:"budget_currency"`
 	RelFundingBodyRecord       string `json:"funding_body_record"`
 	RelFundingBodyCreatedBy    string `json:"funding_body_created_by,omitempty"`
 	RelFundingBodyUpdatedBy    string `json:"funding_body_updated_by,omitempty"`
@@ -33,8 +38,8 @@ type FinancedProject struct {
 	RelResearchLineCreatedAt   int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) FinancedProjectCreate(title string, started, ended, budget int64, scope string, createdBy string, primaryFundingBody int64, primaryRecord string, primaryLeader int64) (id int64, verr *ValidationError, err error) {
-	verr = financedProjectValidate(title, started, ended, budget, scope)
+func (dbp *DBProvider) FinancedProjectCreate(title string, started, ended, budget int64, scope string, createdBy string, primaryFundingBody int64, primaryRecord string, primaryLeader int64) (id int64, verr ValidationErrors, err error) {
+	verr = FinancedProjectValidate(title, started, ended, budget, scope)
 	if verr != nil {
 		return
 	}
@@ -53,7 +58,7 @@ func (dbp *DBProvider) FinancedProjectCreate(title string, started, ended, budge
 	result, err := stmt.Exec(title, started, ended, budget, scope, createdBy, createdBy, ts, ts, primaryFundingBody, primaryRecord, primaryLeader)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -65,11 +70,20 @@ func (dbp *DBProvider) FinancedProjectCreate(title string, started, ended, budge
 	}
 	return
 }
-func (dbp *DBProvider) FinancedProjectUpdate(id int64, title string, started, ended, budget int64, scope string, updatedBy string, primaryFundingBody int64, primaryRecord string, primaryLeader int64) (numRows int64, verr *ValidationError, err error) {
-	verr = financedProjectValidate(title, started, ended, budget, scope)
+func (dbp *DBProvider) FinancedProjectUpdate(id int64, title string, started, ended, budget int64, scope string, updatedBy string, primaryFundingBody int64, primaryRecord string, primaryLeader int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = FinancedProjectValidate(title, started, ended, budget, scope)
 	if verr != nil {
 		return
 	}
+	// the budget cannot drift from its breakdown, which has to be cleared with FinancedProjectSetBudget first
+	total, count, err := dbp.financedProjectBudgetTotal(id)
+	if err != nil {
+		return
+	}
+	if count != 0 && total != budget {
+		verr = ValidationErrors{{"budget", fmt.Sprintf("does not match the budget breakdown, which sums %d", total), ValidationCodeConflict}}
+		return
+	}
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -85,7 +99,7 @@ func (dbp *DBProvider) FinancedProjectUpdate(id int64, title string, started, en
 	result, err := stmt.Exec(title, started, ended, budget, scope, updatedBy, ts, primaryFundingBody, primaryRecord, primaryLeader, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -97,6 +111,72 @@ func (dbp *DBProvider) FinancedProjectUpdate(id int64, title string, started, en
 	}
 	return
 }
+
+// FinancedProjectUpdateBudgetCurrency sets the ISO 4217 currency of the budget and its breakdown. Amounts are
+// rescaled to the minor units of currency without converting them, 10.50 EUR becomes 10.50 USD, and the whole
+// amounts of a project that had no currency yet are taken as major units. The change is rejected when an amount
+// has more decimals than currency allows.
+func (dbp *DBProvider) FinancedProjectUpdateBudgetCurrency(id int64, currency string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	verr = validateCurrency("budget_currency", currency)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var previous string
+	err = tx.QueryRow("SELECT budget_currency FROM financed_project WHERE id=? FOR UPDATE", id).Scan((*nullString)(&previous))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = tx.Rollback()
+		}
+		return
+	}
+	digits := NewMoney(0, currency).MinorUnits()
+	if previous != "" {
+		digits -= NewMoney(0, previous).MinorUnits()
+	}
+	operator, factor := "*", int64(math.Pow10(digits))
+	if digits < 0 {
+		operator, factor = " DIV ", int64(math.Pow10(-digits))
+		var fractional int64
+		query := "SELECT (SELECT COUNT(id) FROM financed_project WHERE id=? AND budget MOD ?<>0)+(SELECT COUNT(id) FROM financed_project_budget WHERE financed_project=? AND amount MOD ?<>0)"
+		err = tx.QueryRow(query, id, factor, id, factor).Scan(&fractional)
+		if err != nil {
+			return
+		}
+		if fractional != 0 {
+			tx.Rollback()
+			verr = &ValidationError{"budget_currency", "amounts have more decimals than the currency allows", ValidationCodeConflict}
+			return
+		}
+	}
+	ts := time.Now().Unix()
+	numRows, err = txExec(tx, "UPDATE financed_project SET budget=budget"+operator+"?,budget_currency=?,updated_by=?,updated_at=? WHERE id=?", factor, currency, updatedBy, ts, id)
+	if err != nil {
+		return
+	}
+	if factor != 1 {
+		_, err = txExec(tx, "UPDATE financed_project_budget SET amount=amount"+operator+"?,updated_by=?,updated_at=? WHERE financed_project=?", factor, updatedBy, ts, id)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
 func (dbp *DBProvider) FinancedProjectDelete(id int64) (numRows int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -117,6 +197,13 @@ func (dbp *DBProvider) FinancedProjectDelete(id int64) (numRows int64, err error
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.categoryUntagAll(EntityTypeFinancedProject, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeFinancedProject, id)
+	}
 	return
 }
 func (dbp *DBProvider) FinancedProjectGetAll() (financedProjects []*FinancedProject, err error) {
@@ -138,7 +225,7 @@ func (dbp *DBProvider) FinancedProjectGetAll() (financedProjects []*FinancedProj
 	defer rows.Close()
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency))
 		if err != nil {
 			return
 		}
@@ -163,7 +250,7 @@ func (dbp *DBProvider) FinancedProjectGetById(id int64) (financedProject *Financ
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&financedProject.Id, &financedProject.Title, &financedProject.Started, &financedProject.Ended, &financedProject.Budget, &financedProject.Scope, &financedProject.CreatedBy, &financedProject.UpdatedBy, &financedProject.CreatedAt, &financedProject.UpdatedAt, &financedProject.PrimaryFundingBody, &financedProject.PrimaryRecord, &financedProject.PrimaryLeader)
+	err = stmt.QueryRow(id).Scan(&financedProject.Id, &financedProject.Title, &financedProject.Started, &financedProject.Ended, &financedProject.Budget, &financedProject.Scope, &financedProject.CreatedBy, &financedProject.UpdatedBy, &financedProject.CreatedAt, &financedProject.UpdatedAt, &financedProject.PrimaryFundingBody, &financedProject.PrimaryRecord, &financedProject.PrimaryLeader, (*nullString)(&financedProject.BudgetCurrency))
 	if err != nil {
 		return
 	}
@@ -188,7 +275,7 @@ func (dbp *DBProvider) FinancedProjectGetByPrimaryFundingBody(fundingBodyId int6
 	defer rows.Close()
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency))
 		if err != nil {
 			return
 		}
@@ -219,7 +306,7 @@ func (dbp *DBProvider) FinancedProjectGetByPrimaryLeader(leaderId int64) (financ
 	defer rows.Close()
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency))
 		if err != nil {
 			return
 		}
@@ -250,7 +337,7 @@ func (dbp *DBProvider) FinancedProjectGetByFundingBody(fundingBodyId int64) (fin
 	defer rows.Close()
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, &p.RelFundingBodyRecord, &p.RelFundingBodyCreatedBy, &p.RelFundingBodyUpdatedBy, &p.RelFundingBodyCreatedAt, &p.RelFundingBodyUpdatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency), &p.RelFundingBodyRecord, &p.RelFundingBodyCreatedBy, &p.RelFundingBodyUpdatedBy, &p.RelFundingBodyCreatedAt, &p.RelFundingBodyUpdatedAt)
 		if err != nil {
 			return
 		}
@@ -268,23 +355,29 @@ func (dbp *DBProvider) FinancedProjectGetByLeader(leaderId int64) (financedProje
 		return
 	}
 	defer db.Close()
-	query := "SELECT financed_project.*,financed_project_leader.created_by,financed_project_leader.created_at FROM financed_project_leader INNER JOIN financed_project ON financed_project_leader.financed_project=financed_project.id  WHERE member=?"
+	query := "SELECT financed_project.*,financed_project_participant.created_by,financed_project_participant.created_at FROM financed_project_participant INNER JOIN financed_project ON financed_project_participant.financed_project=financed_project.id WHERE member=? AND role IN (" + sqlPlaceholders(len(financedProjectLeaderRoles)) + ") ORDER BY financed_project_participant.id ASC"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(leaderId)
+	rows, err := stmt.Query(financedProjectGroupArgs(financedProjectLeaderRoles, leaderId)...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
+	// several participations of the same member in the same project are listed once
+	seen := make(map[int64]bool)
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, &p.RelMemberAsLeaderCreatedBy, &p.RelMemberAsLeaderCreatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency), &p.RelMemberAsLeaderCreatedBy, &p.RelMemberAsLeaderCreatedAt)
 		if err != nil {
 			return
 		}
+		if seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
 		financedProjects = append(financedProjects, &p)
 	}
 	err = rows.Err()
@@ -299,23 +392,29 @@ func (dbp *DBProvider) FinancedProjectGetByMember(memberId int64) (financedProje
 		return
 	}
 	defer db.Close()
-	query := "SELECT financed_project.*,financed_project_member.created_by,financed_project_member.created_at FROM financed_project_member INNER JOIN financed_project ON financed_project_member.financed_project=financed_project.id  WHERE member=?"
+	query := "SELECT financed_project.*,financed_project_participant.created_by,financed_project_participant.created_at FROM financed_project_participant INNER JOIN financed_project ON financed_project_participant.financed_project=financed_project.id WHERE member=? AND role IN (" + sqlPlaceholders(len(financedProjectMemberRoles)) + ") ORDER BY financed_project_participant.id ASC"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(memberId)
+	rows, err := stmt.Query(financedProjectGroupArgs(financedProjectMemberRoles, memberId)...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
+	// several participations of the same member in the same project are listed once
+	seen := make(map[int64]bool)
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, &p.RelMemberCreatedBy, &p.RelMemberCreatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency), &p.RelMemberCreatedBy, &p.RelMemberCreatedAt)
 		if err != nil {
 			return
 		}
+		if seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
 		financedProjects = append(financedProjects, &p)
 	}
 	err = rows.Err()
@@ -343,7 +442,38 @@ func (dbp *DBProvider) FinancedProjectGetByResearchLine(researchLineId int64) (f
 	defer rows.Close()
 	for rows.Next() {
 		p := FinancedProject{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, &p.RelResearchLineCreatedby, &p.RelResearchLineCreatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency), &p.RelResearchLineCreatedby, &p.RelResearchLineCreatedAt)
+		if err != nil {
+			return
+		}
+		financedProjects = append(financedProjects, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) FinancedProjectGetByCategory(categoryId int64) (financedProjects []*FinancedProject, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT financed_project.* FROM category_tag INNER JOIN financed_project ON category_tag.entity_id=financed_project.id WHERE category_tag.category=? AND category_tag.entity_type=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId, EntityTypeFinancedProject)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := FinancedProject{}
+		err = rows.Scan(&p.Id, &p.Title, &p.Started, &p.Ended, &p.Budget, &p.Scope, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryFundingBody, &p.PrimaryRecord, &p.PrimaryLeader, (*nullString)(&p.BudgetCurrency))
 		if err != nil {
 			return
 		}
@@ -407,7 +537,7 @@ func (dbp *DBProvider) FinancedProjectAddFundingBody(id, fundingBodyId int64, re
 		return
 	}
 	if financedProject.PrimaryFundingBody == fundingBodyId {
-		verr = &ValidationError{"funding_body", "this funding body is already the primary"}
+		verr = &ValidationError{"funding_body", "this funding body is already the primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -425,12 +555,12 @@ func (dbp *DBProvider) FinancedProjectAddFundingBody(id, fundingBodyId int64, re
 	_, err = stmt.Exec(fundingBodyId, id, record, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"funding_body", "this funding body has already been added"}
+			verr = &ValidationError{"funding_body", "this funding body has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -474,60 +604,14 @@ func (dbp *DBProvider) FinancedProjectAddLeader(id, leaderId int64, createdBy st
 		return
 	}
 	if financedProject.PrimaryLeader == leaderId {
-		verr = &ValidationError{"leader", "this leader is already the primary"}
-		return
-	}
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO financed_project_leader(financed_project,member,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(id, leaderId, createdBy, ts)
-	if err != nil {
-		if IsDbError1062(err) {
-			verr = &ValidationError{"leader", "this leader has already been added"}
-			err = nil
-			return
-		}
-		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
-			err = nil
-			return
-		}
+		verr = &ValidationError{"leader", "this leader is already the primary", ValidationCodeConflict}
 		return
 	}
+	verr, err = dbp.financedProjectAddToGroup(id, leaderId, FinancedProjectRoleCoPrincipalInvestigator, financedProjectLeaderRoles, &ValidationError{"leader", "this leader has already been added", ValidationCodeDuplicate}, createdBy)
 	return
 }
 func (dbp *DBProvider) FinancedProjectRemoveLeader(id, leaderId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM financed_project_leader WHERE member=? AND financed_project=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(leaderId, id)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.financedProjectRemoveFromGroup(id, leaderId, financedProjectLeaderRoles)
 	return
 }
 
@@ -537,57 +621,11 @@ func (dbp *DBProvider) FinancedProjectGetLeaders(id int64) (leaders []*Member, e
 }
 
 func (dbp *DBProvider) FinancedProjectAddMember(id, memberId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO financed_project_member(financed_project,member,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(id, memberId, createdBy, ts)
-	if err != nil {
-		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this member has already been added"}
-			err = nil
-			return
-		}
-		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
-			err = nil
-			return
-		}
-		return
-	}
+	verr, err = dbp.financedProjectAddToGroup(id, memberId, FinancedProjectRoleResearcher, financedProjectMemberRoles, &ValidationError{"member", "this member has already been added", ValidationCodeDuplicate}, createdBy)
 	return
 }
 func (dbp *DBProvider) FinancedProjectRemoveMember(id, memberId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM financed_project_member WHERE member=? AND financed_project=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(memberId, id)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.financedProjectRemoveFromGroup(id, memberId, financedProjectMemberRoles)
 	return
 }
 
@@ -611,12 +649,12 @@ func (dbp *DBProvider) FinancedProjectAddResearchLine(id, researchLineId int64,
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research line has already been added"}
+			verr = &ValidationError{"research_line", "this research line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -669,6 +707,7 @@ func (dbp *DBProvider) FinancedProjectGetColumns() []string {
 		"primary_funding_body",
 		"primary_record",
 		"primary_leader",
+		"budget_currency",
 	}
 	return columns
 }
@@ -682,7 +721,11 @@ func financedProjectValidateStartedAndEnded(started, ended int64) (verr *Validat
 	if verr = validateIsNumber("started", started); verr != nil {
 		return verr
 	}
-	return nil
+	if verr = validateIsNumber("ended", ended); verr != nil {
+		return verr
+	}
+	// a zero end means the project is still running
+	return validateNotBefore("ended", ended, "started", started)
 }
 func financedProjectValidateBudget(budget int64) (verr *ValidationError) {
 	return validateIsNumber("budget", budget)
@@ -693,22 +736,10 @@ func financedProjectValidateScope(scope string) (verr *ValidationError) {
 func financedProjectValidateRecord(record string) (err *ValidationError) {
 	return validateLength("record", record, 200)
 }
-func financedProjectValidate(title string, started, ended, budget int64, scope string) (verr *ValidationError) {
-	verr = financedProjectValidateTitle(title)
-	if verr != nil {
-		return
-	}
-	verr = financedProjectValidateStartedAndEnded(started, ended)
-	if verr != nil {
-		return
-	}
-	verr = financedProjectValidateBudget(budget)
-	if verr != nil {
-		return
-	}
-	verr = financedProjectValidateScope(scope)
-	if verr != nil {
-		return
-	}
+func FinancedProjectValidate(title string, started, ended, budget int64, scope string) (verrs ValidationErrors) {
+	verrs.add(financedProjectValidateTitle(title))
+	verrs.add(financedProjectValidateStartedAndEnded(started, ended))
+	verrs.add(financedProjectValidateBudget(budget))
+	verrs.add(financedProjectValidateScope(scope))
 	return
 }
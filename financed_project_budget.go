@@ -0,0 +1,288 @@
+package instantolib
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const (
+	BudgetCategoryPersonnel = "personnel"
+	BudgetCategoryEquipment = "equipment"
+	BudgetCategoryTravel    = "travel"
+	BudgetCategoryOverhead  = "overhead"
+)
+
+var budgetCategories = []string{BudgetCategoryPersonnel, BudgetCategoryEquipment, BudgetCategoryTravel, BudgetCategoryOverhead}
+
+// FinancedProjectBudgetLine is the amount a funding body contributes to a financed project in a year for a cost category.
+// Amount is in minor units of the budget currency of the project, or in whole units if it has none, as its budget.
+type FinancedProjectBudgetLine struct {
+	Id              int64  `json:"id"`
+	FinancedProject int64  `json:"financed_project"`
+	FundingBody     int64  `json:"funding_body"`
+	Year            int64  `json:"year"`
+	Category        string `json:"category"`
+	Amount          int64  `json:"amount"`
+	CreatedBy       string `json:"created_by"`
+	UpdatedBy       string `json:"updated_by"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+}
+
+// FundingByYear is the amount of funding of a year in minor units of Currency,
+// for a single funding body when FundingBody is not 0
+type FundingByYear struct {
+	Year        int64  `json:"year"`
+	FundingBody int64  `json:"funding_body,omitempty"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+}
+
+// FinancedProjectSetBudget replaces the budget breakdown of a financed project with lines.
+// The lines must sum to the budget of the project and their funding bodies must fund the project.
+func (dbp *DBProvider) FinancedProjectSetBudget(id int64, lines []*FinancedProjectBudgetLine, updatedBy string) (verr ValidationErrors, err error) {
+	financedProject, err := dbp.FinancedProjectGetById(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = ValidationErrors{{"financed_project", "not exist", ValidationCodeNotFound}}
+			err = nil
+		}
+		return
+	}
+	fundingBodies, err := dbp.FundingBodyGetByFinancedProject(id)
+	if err != nil {
+		return
+	}
+	funders := map[int64]bool{financedProject.PrimaryFundingBody: true}
+	for _, fundingBody := range fundingBodies {
+		funders[fundingBody.Id] = true
+	}
+	verr = FinancedProjectValidateBudget(financedProject, lines)
+	for _, line := range lines {
+		if !funders[line.FundingBody] {
+			verr.add(&ValidationError{"funding_body", "does not fund the financed project", ValidationCodeInvalidValue})
+			break
+		}
+	}
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = txExec(tx, "DELETE FROM financed_project_budget WHERE financed_project=?", id)
+	if err != nil {
+		return
+	}
+	ts := time.Now().Unix()
+	for _, line := range lines {
+		_, err = txExec(tx, "INSERT INTO financed_project_budget(financed_project,funding_body,year,category,amount,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?)", id, line.FundingBody, line.Year, line.Category, line.Amount, updatedBy, updatedBy, ts, ts)
+		if err != nil {
+			if IsDbError1062(err) {
+				tx.Rollback()
+				verr = ValidationErrors{{"category", "this category has already been added for the funding body and year", ValidationCodeDuplicate}}
+				err = nil
+				return
+			}
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) FinancedProjectGetBudget(id int64) (lines []*FinancedProjectBudgetLine, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM financed_project_budget WHERE financed_project=? ORDER BY year ASC,funding_body ASC,category ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := FinancedProjectBudgetLine{}
+		err = rows.Scan(&p.Id, &p.FinancedProject, &p.FundingBody, &p.Year, &p.Category, &p.Amount, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		lines = append(lines, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// financedProjectBudgetTotal returns the sum of the budget breakdown of a financed project and its number of lines
+func (dbp *DBProvider) financedProjectBudgetTotal(id int64) (total, count int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COALESCE(SUM(amount),0),COUNT(*) FROM financed_project_budget WHERE financed_project=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(id).Scan(&total, &count)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// FinancedProjectGetFundingByYear returns the funding of every year across all financed projects in currency
+func (dbp *DBProvider) FinancedProjectGetFundingByYear(currency string) (funding []*FundingByYear, err error) {
+	funding, err = dbp.financedProjectGetFunding(false, currency)
+	return
+}
+
+// FinancedProjectGetFundingByYearAndFundingBody returns the funding of every year split by funding body in currency
+func (dbp *DBProvider) FinancedProjectGetFundingByYearAndFundingBody(currency string) (funding []*FundingByYear, err error) {
+	funding, err = dbp.financedProjectGetFunding(true, currency)
+	return
+}
+
+// financedProjectGetFunding sums the budget lines per year, and per funding body if byFundingBody.
+// Amounts of each year are converted with the exchange rates in force at the end of that year.
+func (dbp *DBProvider) financedProjectGetFunding(byFundingBody bool, currency string) (funding []*FundingByYear, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	fundingBodyColumn := "0"
+	if byFundingBody {
+		fundingBodyColumn = "financed_project_budget.funding_body"
+	}
+	query := "SELECT financed_project_budget.year," + fundingBodyColumn + ",financed_project.budget_currency,SUM(financed_project_budget.amount) FROM financed_project_budget INNER JOIN financed_project ON financed_project_budget.financed_project=financed_project.id GROUP BY 1,2,3 ORDER BY 1 ASC,2 ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	type fundingSum struct {
+		year, fundingBody int64
+		amount            Money
+	}
+	var sums []*fundingSum
+	for rows.Next() {
+		p := fundingSum{}
+		var budgetCurrency string
+		err = rows.Scan(&p.year, &p.fundingBody, (*nullString)(&budgetCurrency), &p.amount.Amount)
+		if err != nil {
+			return
+		}
+		p.amount = budgetMoney(p.amount.Amount, budgetCurrency)
+		sums = append(sums, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	rates, err := dbp.ExchangeRateGetAll()
+	if err != nil {
+		return
+	}
+	currency = NewMoney(0, currency).Currency
+	var last *FundingByYear
+	for _, sum := range sums {
+		converted := sum.amount
+		if converted.Currency != currency {
+			endOfYear := time.Date(int(sum.year), time.December, 31, 23, 59, 59, 0, time.UTC).Unix()
+			var factor float64
+			factor, err = exchangeFactor(rates, sum.amount.Currency, currency, endOfYear)
+			if err != nil {
+				return
+			}
+			converted = sum.amount.convert(currency, factor)
+		}
+		if last == nil || last.Year != sum.year || last.FundingBody != sum.fundingBody {
+			last = &FundingByYear{Year: sum.year, FundingBody: sum.fundingBody, Currency: converted.Currency}
+			funding = append(funding, last)
+		}
+		last.Amount += converted.Amount
+	}
+	return
+}
+
+func (dbp *DBProvider) FinancedProjectBudgetLineGetColumns() []string {
+	return []string{"id", "financed_project", "funding_body", "year", "category", "amount", "created_by", "updated_by", "created_at", "updated_at"}
+}
+
+func financedProjectValidateBudgetCategory(category string) (verr *ValidationError) {
+	for _, c := range budgetCategories {
+		if c == category {
+			return nil
+		}
+	}
+	return &ValidationError{"category", fmt.Sprintf("must be one of %s", strings.Join(budgetCategories, ", ")), ValidationCodeInvalidValue}
+}
+
+// financedProjectValidateBudgetYear checks year is a valid year inside the period of the financed project
+func financedProjectValidateBudgetYear(financedProject *FinancedProject, year int64) (verr *ValidationError) {
+	if verr = validateYear("year", year); verr != nil {
+		return verr
+	}
+	if financedProject.Started != 0 && year < int64(time.Unix(financedProject.Started, 0).Year()) {
+		return &ValidationError{"year", "cannot be before the start of the financed project", ValidationCodeOutOfRange}
+	}
+	if financedProject.Ended != 0 && year > int64(time.Unix(financedProject.Ended, 0).Year()) {
+		return &ValidationError{"year", "cannot be after the end of the financed project", ValidationCodeOutOfRange}
+	}
+	return nil
+}
+func financedProjectValidateBudgetLine(financedProject *FinancedProject, line *FinancedProjectBudgetLine) (verrs ValidationErrors) {
+	verrs.add(validateIsNumber("funding_body", line.FundingBody))
+	verrs.add(financedProjectValidateBudgetYear(financedProject, line.Year))
+	verrs.add(financedProjectValidateBudgetCategory(line.Category))
+	verrs.add(validateIsNumber("amount", line.Amount))
+	return
+}
+
+// FinancedProjectValidateBudget checks every line of a budget breakdown and that they sum to the budget of the financed project
+func FinancedProjectValidateBudget(financedProject *FinancedProject, lines []*FinancedProjectBudgetLine) (verrs ValidationErrors) {
+	var total int64
+	for _, line := range lines {
+		verrs = append(verrs, financedProjectValidateBudgetLine(financedProject, line)...)
+		total += line.Amount
+	}
+	if len(lines) != 0 && total != financedProject.Budget {
+		verrs.add(&ValidationError{"amount", fmt.Sprintf("the breakdown sums %d but the budget is %d", total, financedProject.Budget), ValidationCodeConflict})
+	}
+	return
+}
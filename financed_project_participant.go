@@ -0,0 +1,408 @@
+package instantolib
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const (
+	FinancedProjectRolePrincipalInvestigator   = "principal_investigator"
+	FinancedProjectRoleCoPrincipalInvestigator = "co_principal_investigator"
+	FinancedProjectRoleResearcher              = "researcher"
+	FinancedProjectRoleContractedStaff         = "contracted_staff"
+)
+
+// financedProjectLeaderRoles and financedProjectMemberRoles are the roles behind the leaders and members of a financed project
+var (
+	financedProjectLeaderRoles = []string{FinancedProjectRolePrincipalInvestigator, FinancedProjectRoleCoPrincipalInvestigator}
+	financedProjectMemberRoles = []string{FinancedProjectRoleResearcher, FinancedProjectRoleContractedStaff}
+)
+
+// FinancedProjectParticipant is the participation of a member in a financed project.
+// Started and Ended are unix timestamps, 0 leaves that side of the period open.
+// Dedication is the percentage of the member time devoted to the project, 0 if unknown.
+type FinancedProjectParticipant struct {
+	Id              int64  `json:"id"`
+	FinancedProject int64  `json:"financed_project"`
+	Member          int64  `json:"member"`
+	Role            string `json:"role"`
+	Started         int64  `json:"started"`
+	Ended           int64  `json:"ended"`
+	Dedication      int64  `json:"dedication"`
+	CreatedBy       string `json:"created_by"`
+	UpdatedBy       string `json:"updated_by"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+}
+
+// IsActiveAt reports whether the participation period includes date
+func (participant *FinancedProjectParticipant) IsActiveAt(date int64) bool {
+	return (participant.Started == 0 || participant.Started <= date) && (participant.Ended == 0 || participant.Ended >= date)
+}
+
+func (participant *FinancedProjectParticipant) overlaps(started, ended int64) bool {
+	return (participant.Ended == 0 || started == 0 || started <= participant.Ended) && (participant.Started == 0 || ended == 0 || ended >= participant.Started)
+}
+
+// FinancedProjectAddParticipant adds memberId to the financed project with role during the period started to ended.
+// A member cannot hold the same role twice in overlapping periods of the same project.
+func (dbp *DBProvider) FinancedProjectAddParticipant(id, memberId int64, role string, started, ended, dedication int64, createdBy string) (participantId int64, verr ValidationErrors, err error) {
+	verr = FinancedProjectParticipantValidate(role, started, ended, dedication)
+	if verr != nil {
+		return
+	}
+	verr, err = dbp.financedProjectParticipantCheck(0, id, memberId, role, started, ended)
+	if err != nil || verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO financed_project_participant(financed_project,member,role,started,ended,dedication,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(id, memberId, role, started, ended, dedication, createdBy, createdBy, ts, ts)
+	if err != nil {
+		if is, field := IsDbError1452(err); is {
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
+			err = nil
+			return
+		}
+		return
+	}
+	participantId, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) FinancedProjectUpdateParticipant(participantId int64, role string, started, ended, dedication int64, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = FinancedProjectParticipantValidate(role, started, ended, dedication)
+	if verr != nil {
+		return
+	}
+	participant, err := dbp.FinancedProjectGetParticipantById(participantId)
+	if err != nil {
+		return
+	}
+	verr, err = dbp.financedProjectParticipantCheck(participantId, participant.FinancedProject, participant.Member, role, started, ended)
+	if err != nil || verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE financed_project_participant SET role=?,started=?,ended=?,dedication=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(role, started, ended, dedication, updatedBy, ts, participantId)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) FinancedProjectRemoveParticipant(participantId int64) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM financed_project_participant WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(participantId)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+func (dbp *DBProvider) FinancedProjectGetParticipantById(participantId int64) (participant *FinancedProjectParticipant, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM financed_project_participant WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	participant = &FinancedProjectParticipant{}
+	err = stmt.QueryRow(participantId).Scan(&participant.Id, &participant.FinancedProject, &participant.Member, &participant.Role, &participant.Started, &participant.Ended, &participant.Dedication, &participant.CreatedBy, &participant.UpdatedBy, &participant.CreatedAt, &participant.UpdatedAt)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// FinancedProjectGetParticipants returns every participation of the financed project, past and future ones included
+func (dbp *DBProvider) FinancedProjectGetParticipants(id int64) (participants []*FinancedProjectParticipant, err error) {
+	participants, err = dbp.financedProjectGetParticipants("SELECT * FROM financed_project_participant WHERE financed_project=? ORDER BY started ASC,id ASC", id)
+	return
+}
+
+// FinancedProjectGetParticipantsAt returns the participations of the financed project active at date
+func (dbp *DBProvider) FinancedProjectGetParticipantsAt(id, date int64) (participants []*FinancedProjectParticipant, err error) {
+	participants, err = dbp.financedProjectGetParticipants("SELECT * FROM financed_project_participant WHERE financed_project=? AND (started=0 OR started<=?) AND (ended=0 OR ended>=?) ORDER BY started ASC,id ASC", id, date, date)
+	return
+}
+
+// FinancedProjectGetParticipationsByMember returns every participation of a member in any financed project
+func (dbp *DBProvider) FinancedProjectGetParticipationsByMember(memberId int64) (participants []*FinancedProjectParticipant, err error) {
+	participants, err = dbp.financedProjectGetParticipants("SELECT * FROM financed_project_participant WHERE member=? ORDER BY started ASC,id ASC", memberId)
+	return
+}
+func (dbp *DBProvider) financedProjectGetParticipants(query string, args ...interface{}) (participants []*FinancedProjectParticipant, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := FinancedProjectParticipant{}
+		err = rows.Scan(&p.Id, &p.FinancedProject, &p.Member, &p.Role, &p.Started, &p.Ended, &p.Dedication, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		participants = append(participants, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// financedProjectParticipantCheck checks the participation against the financed project and the other
+// participations of the member, skipping participantId when it is being updated
+func (dbp *DBProvider) financedProjectParticipantCheck(participantId, id, memberId int64, role string, started, ended int64) (verr ValidationErrors, err error) {
+	financedProject, err := dbp.FinancedProjectGetById(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = ValidationErrors{{"financed_project", "not exist", ValidationCodeNotFound}}
+			err = nil
+		}
+		return
+	}
+	if role == FinancedProjectRoleCoPrincipalInvestigator && financedProject.PrimaryLeader == memberId {
+		verr.add(&ValidationError{"role", "this member is already the primary leader", ValidationCodeConflict})
+	}
+	if financedProject.Started != 0 && started != 0 && started < financedProject.Started {
+		verr.add(&ValidationError{"started", "cannot be before the start of the financed project", ValidationCodeOutOfRange})
+	}
+	if financedProject.Ended != 0 && ended != 0 && ended > financedProject.Ended {
+		verr.add(&ValidationError{"ended", "cannot be after the end of the financed project", ValidationCodeOutOfRange})
+	}
+	participants, err := dbp.financedProjectGetParticipants("SELECT * FROM financed_project_participant WHERE financed_project=? AND member=? AND role=?", id, memberId, role)
+	if err != nil {
+		return
+	}
+	for _, participant := range participants {
+		if participant.Id != participantId && participant.overlaps(started, ended) {
+			verr.add(&ValidationError{"member", "this member already has this role in an overlapping period", ValidationCodeConflict})
+			break
+		}
+	}
+	return
+}
+
+// financedProjectAddToGroup backs the leader and member calls, which add a participation with role
+// and open dates unless the member already holds any role of group in the project
+func (dbp *DBProvider) financedProjectAddToGroup(id, memberId int64, role string, group []string, duplicate *ValidationError, createdBy string) (verr *ValidationError, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(*) FROM financed_project_participant WHERE financed_project=? AND member=? AND role IN (" + sqlPlaceholders(len(group)) + ")"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var count int64
+	err = stmt.QueryRow(financedProjectGroupArgs(group, id, memberId)...).Scan(&count)
+	if err != nil {
+		return
+	}
+	if count != 0 {
+		verr = duplicate
+		return
+	}
+	_, verrs, err := dbp.FinancedProjectAddParticipant(id, memberId, role, 0, 0, 0, createdBy)
+	if err != nil {
+		return
+	}
+	if len(verrs) != 0 {
+		verr = verrs[0]
+	}
+	return
+}
+func (dbp *DBProvider) financedProjectRemoveFromGroup(id, memberId int64, group []string) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM financed_project_participant WHERE financed_project=? AND member=? AND role IN (" + sqlPlaceholders(len(group)) + ")"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(financedProjectGroupArgs(group, id, memberId)...)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+// financedProjectGroupArgs appends the roles of group to args
+func financedProjectGroupArgs(group []string, args ...interface{}) []interface{} {
+	for _, role := range group {
+		args = append(args, role)
+	}
+	return args
+}
+
+// FinancedProjectMigrateParticipants copies the leaders and members recorded in financed_project_leader and
+// financed_project_member before participations existed into financed_project_participant, leaders as
+// co-principal investigators and members as researchers, with open dates. It is meant to run once after
+// upgrading; the old tables are left untouched and rows already copied are skipped, so running it again is harmless.
+func (dbp *DBProvider) FinancedProjectMigrateParticipants() (leaders, members int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	ts := time.Now().Unix()
+	migrate := func(table, role string, group []string) (int64, error) {
+		query := "INSERT INTO financed_project_participant(financed_project,member,role,started,ended,dedication,created_by,updated_by,created_at,updated_at) SELECT old.financed_project,old.member,?,0,0,0,old.created_by,old.created_by,old.created_at,? FROM " + table + " AS old WHERE NOT EXISTS(SELECT id FROM financed_project_participant WHERE financed_project=old.financed_project AND member=old.member AND role IN (" + sqlPlaceholders(len(group)) + "))"
+		return txExec(tx, query, financedProjectGroupArgs(group, role, ts)...)
+	}
+	leaders, err = migrate("financed_project_leader", FinancedProjectRoleCoPrincipalInvestigator, financedProjectLeaderRoles)
+	if err != nil {
+		return
+	}
+	members, err = migrate("financed_project_member", FinancedProjectRoleResearcher, financedProjectMemberRoles)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// mergeParticipations repoints the participations of dropId to keepId. The ones that would give keepId the same
+// role twice in overlapping periods of a project, or a co-leadership of a project keepId leads, are deleted and
+// reported as skipped.
+func mergeParticipations(tx *sql.Tx, keepId, dropId int64, updatedBy string, ts int64) (rel *MergeRelation, err error) {
+	query := "DELETE FROM financed_project_participant WHERE id IN (SELECT id FROM (SELECT dropped.id FROM financed_project_participant AS dropped INNER JOIN financed_project_participant AS kept ON kept.financed_project=dropped.financed_project AND kept.role=dropped.role AND kept.member=? WHERE dropped.member=? AND (kept.ended=0 OR dropped.started=0 OR dropped.started<=kept.ended) AND (kept.started=0 OR dropped.ended=0 OR dropped.ended>=kept.started)) AS overlapping)"
+	overlapping, err := txExec(tx, query, keepId, dropId)
+	if err != nil {
+		return
+	}
+	leaderships, err := txExec(tx, "DELETE FROM financed_project_participant WHERE member=? AND role=? AND financed_project IN (SELECT id FROM financed_project WHERE primary_leader=?)", dropId, FinancedProjectRoleCoPrincipalInvestigator, keepId)
+	if err != nil {
+		return
+	}
+	rel, err = mergeColumnRelation(tx, "financed_project_participations", "financed_project_participant", "member", keepId, dropId, updatedBy, ts)
+	if err != nil {
+		return
+	}
+	rel.Skipped = overlapping + leaderships
+	return
+}
+
+func (dbp *DBProvider) FinancedProjectParticipantGetColumns() []string {
+	return []string{"id", "financed_project", "member", "role", "started", "ended", "dedication", "created_by", "updated_by", "created_at", "updated_at"}
+}
+
+func financedProjectValidateRole(role string) (verr *ValidationError) {
+	switch role {
+	case FinancedProjectRolePrincipalInvestigator, FinancedProjectRoleCoPrincipalInvestigator, FinancedProjectRoleResearcher, FinancedProjectRoleContractedStaff:
+		return nil
+	}
+	return &ValidationError{"role", fmt.Sprintf("must be one of %s", strings.Join(append(append([]string{}, financedProjectLeaderRoles...), financedProjectMemberRoles...), ", ")), ValidationCodeInvalidValue}
+}
+func financedProjectValidateParticipation(started, ended int64) (verr *ValidationError) {
+	if started != 0 {
+		if verr = validateDate("started", started); verr != nil {
+			return verr
+		}
+	}
+	if ended != 0 {
+		if verr = validateDate("ended", ended); verr != nil {
+			return verr
+		}
+	}
+	return validateNotBefore("ended", ended, "started", started)
+}
+func financedProjectValidateDedication(dedication int64) (verr *ValidationError) {
+	if dedication < 0 || dedication > 100 {
+		return &ValidationError{"dedication", "must be a percentage between 0 and 100", ValidationCodeOutOfRange}
+	}
+	return nil
+}
+func FinancedProjectParticipantValidate(role string, started, ended, dedication int64) (verrs ValidationErrors) {
+	verrs.add(financedProjectValidateRole(role))
+	verrs.add(financedProjectValidateParticipation(started, ended))
+	verrs.add(financedProjectValidateDedication(dedication))
+	return
+}
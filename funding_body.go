@@ -1,6 +1,7 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -22,8 +23,8 @@ type FundingBody struct {
 	RelFinancedProjectUpdatedAt int64  `json:"financed_project_updated_at,omitempty"`
 }
 
-func (dbp *DBProvider) FundingBodyCreate(name, web, scope string, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = fundingBodyValidate(name, web, scope)
+func (dbp *DBProvider) FundingBodyCreate(name, web, scope string, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = FundingBodyValidate(name, web, scope)
 	if verr != nil {
 		return
 	}
@@ -42,7 +43,7 @@ func (dbp *DBProvider) FundingBodyCreate(name, web, scope string, createdBy stri
 	result, err := stmt.Exec(name, web, scope, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -54,8 +55,8 @@ func (dbp *DBProvider) FundingBodyCreate(name, web, scope string, createdBy stri
 	}
 	return
 }
-func (dbp *DBProvider) FundingBodyUpdate(id int64, name, web, scope string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = fundingBodyValidate(name, web, scope)
+func (dbp *DBProvider) FundingBodyUpdate(id int64, name, web, scope string, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = FundingBodyValidate(name, web, scope)
 	if verr != nil {
 		return
 	}
@@ -74,7 +75,7 @@ func (dbp *DBProvider) FundingBodyUpdate(id int64, name, web, scope string, upda
 	result, err := stmt.Exec(name, web, scope, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -106,6 +107,30 @@ func (dbp *DBProvider) FundingBodyDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.attachmentDeleteAll(EntityTypeFundingBody, id)
+	}
+	return
+}
+func (dbp *DBProvider) FundingBodyDeletePreview(id int64) (preview *DeletePreview, err error) {
+	exists, err := dbp.FundingBodyExists(id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = sql.ErrNoRows
+		return
+	}
+	specs := []deletePreviewSpec{
+		{"financed_projects", "funding_body_financed_project", DeletePreviewDetached, "financed_project", "funding_body"},
+		{"financed_projects_as_primary", "financed_project", DeletePreviewDependent, "id", "primary_funding_body"},
+		{"financed_project_budget_lines", "financed_project_budget", DeletePreviewDependent, "id", "funding_body"},
+	}
+	preview, err = dbp.deletePreview(id, specs)
+	if err != nil {
+		return
+	}
+	err = dbp.deletePreviewAttachments(preview, EntityTypeFundingBody, id)
 	return
 }
 func (dbp *DBProvider) FundingBodyGetAll() (fundingBodys []*FundingBody, err error) {
@@ -241,7 +266,7 @@ func (dbp *DBProvider) FundingBodyAddFinancedProject(id, financedProjectId int64
 		return
 	}
 	if financedProject.PrimaryFundingBody == id {
-		verr = &ValidationError{"financed_project", "this financed project has this funding body as primary"}
+		verr = &ValidationError{"financed_project", "this financed project has this funding body as primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -259,12 +284,12 @@ func (dbp *DBProvider) FundingBodyAddFinancedProject(id, financedProjectId int64
 	_, err = stmt.Exec(id, financedProjectId, record, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"financed_project", "this financed project has already been added"}
+			verr = &ValidationError{"financed_project", "this financed project has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -323,7 +348,10 @@ func fundingBodyValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 func fundingBodyValidateWeb(web string) (verr *ValidationError) {
-	return validateLength("web", web, 200)
+	if verr = validateLength("web", web, 200); verr != nil {
+		return verr
+	}
+	return validateURL("web", web)
 }
 func fundingBodyValidateScope(scope string) (verr *ValidationError) {
 	return validateScope("scope", scope)
@@ -331,18 +359,9 @@ func fundingBodyValidateScope(scope string) (verr *ValidationError) {
 func fundingBodyValidateRecord(record string) (err *ValidationError) {
 	return validateLength("record", record, 200)
 }
-func fundingBodyValidate(name, web, scope string) (verr *ValidationError) {
-	verr = fundingBodyValidateName(name)
-	if verr != nil {
-		return
-	}
-	verr = fundingBodyValidateWeb(web)
-	if verr != nil {
-		return
-	}
-	verr = fundingBodyValidateScope(scope)
-	if verr != nil {
-		return
-	}
+func FundingBodyValidate(name, web, scope string) (verrs ValidationErrors) {
+	verrs.add(fundingBodyValidateName(name))
+	verrs.add(fundingBodyValidateWeb(web))
+	verrs.add(fundingBodyValidateScope(scope))
 	return
 }
@@ -0,0 +1,525 @@
+package instantolib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Entity types with images besides the ones in entityTables
+const (
+	EntityTypePartner   = "partner"
+	EntityTypeNewspaper = "newspaper"
+)
+
+const (
+	// ImageMaxBytes is the largest image that can be uploaded
+	ImageMaxBytes = 20 << 20
+	// ImageMinDimension is the smallest width and height of an uploaded image
+	ImageMinDimension = 16
+	// ImageMaxDimension is the largest width and height of an uploaded image
+	ImageMaxDimension = 6000
+)
+
+// imageVariantSizes are the sizes in pixels of the longest side of the variants generated for every image,
+// smallest first. Images are never enlarged, so the variants of a small image can be smaller than their size.
+var imageVariantSizes = []int64{64, 256, 1024}
+
+// imageTargets maps the entity types with images to the table and column holding the URL of the image
+var imageTargets = map[string]struct{ table, column string }{
+	EntityTypeMember:       {"member", "photo"},
+	EntityTypePartner:      {"partner", "logo"},
+	EntityTypeNewspaper:    {"newspaper", "logo"},
+	EntityTypeResearchArea: {"research_area", "logo"},
+	EntityTypeResearchLine: {"research_line", "logo"},
+}
+
+// ImageVariant is an uploaded image scaled down to fit in a square of Size pixels.
+// URL is relative to the base URL set with SetImageBaseURL, and Path is only set with a LocalBlobStore.
+type ImageVariant struct {
+	Id         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Size       int64  `json:"size"`
+	Width      int64  `json:"width"`
+	Height     int64  `json:"height"`
+	MimeType   string `json:"mime_type"`
+	BlobKey    string `json:"-"`
+	CreatedBy  string `json:"created_by"`
+	CreatedAt  int64  `json:"created_at"`
+	URL        string `json:"url"`
+	Path       string `json:"path,omitempty"`
+}
+
+// MemberUploadPhoto replaces the photo of a member with the image read from r. See imageUpload.
+func (dbp *DBProvider) MemberUploadPhoto(id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	variants, verr, err = dbp.imageUpload(EntityTypeMember, id, r, updatedBy)
+	return
+}
+func (dbp *DBProvider) MemberGetPhotoVariants(id int64) (variants []*ImageVariant, err error) {
+	variants, err = dbp.imageGetVariants(EntityTypeMember, id)
+	return
+}
+
+// PartnerUploadLogo replaces the logo of a partner with the image read from r. See imageUpload.
+func (dbp *DBProvider) PartnerUploadLogo(id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	variants, verr, err = dbp.imageUpload(EntityTypePartner, id, r, updatedBy)
+	return
+}
+func (dbp *DBProvider) PartnerGetLogoVariants(id int64) (variants []*ImageVariant, err error) {
+	variants, err = dbp.imageGetVariants(EntityTypePartner, id)
+	return
+}
+
+// NewspaperUploadLogo replaces the logo of a newspaper with the image read from r. See imageUpload.
+func (dbp *DBProvider) NewspaperUploadLogo(id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	variants, verr, err = dbp.imageUpload(EntityTypeNewspaper, id, r, updatedBy)
+	return
+}
+func (dbp *DBProvider) NewspaperGetLogoVariants(id int64) (variants []*ImageVariant, err error) {
+	variants, err = dbp.imageGetVariants(EntityTypeNewspaper, id)
+	return
+}
+
+// ResearchAreaUploadLogo replaces the logo of a research area with the image read from r. See imageUpload.
+func (dbp *DBProvider) ResearchAreaUploadLogo(id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	variants, verr, err = dbp.imageUpload(EntityTypeResearchArea, id, r, updatedBy)
+	return
+}
+func (dbp *DBProvider) ResearchAreaGetLogoVariants(id int64) (variants []*ImageVariant, err error) {
+	variants, err = dbp.imageGetVariants(EntityTypeResearchArea, id)
+	return
+}
+
+// ResearchLineUploadLogo replaces the logo of a research line with the image read from r. See imageUpload.
+func (dbp *DBProvider) ResearchLineUploadLogo(id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	variants, verr, err = dbp.imageUpload(EntityTypeResearchLine, id, r, updatedBy)
+	return
+}
+func (dbp *DBProvider) ResearchLineGetLogoVariants(id int64) (variants []*ImageVariant, err error) {
+	variants, err = dbp.imageGetVariants(EntityTypeResearchLine, id)
+	return
+}
+
+// ImageOpen returns the contents of an image variant
+func (dbp *DBProvider) ImageOpen(variant *ImageVariant) (rc io.ReadCloser, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	rc, err = dbp.blobs.Open(variant.BlobKey)
+	return
+}
+
+// imageUpload checks the image read from r is a PNG, JPEG or GIF within the allowed dimensions
+// and stores a variant of it for every size in imageVariantSizes, replacing the previous ones.
+// The variants are encoded again, which drops EXIF and any other metadata, so JPEG images are
+// turned upright following their EXIF orientation first. The photo or logo
+// column of the entity is set to the URL of the largest variant.
+func (dbp *DBProvider) imageUpload(entityType string, id int64, r io.Reader, updatedBy string) (variants []*ImageVariant, verr ValidationErrors, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	target := imageTargets[entityType]
+	exists, err := dbp.rowExists(target.table, id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		verr = ValidationErrors{{entityType, "not exist", ValidationCodeNotFound}}
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, ImageMaxBytes+1))
+	if err != nil {
+		return
+	}
+	if len(data) > ImageMaxBytes {
+		verr = ValidationErrors{{"image", fmt.Sprintf("cannot be larger than %d bytes", ImageMaxBytes), ValidationCodeOutOfRange}}
+		return
+	}
+	// the dimensions are checked before decoding, so huge images are never held in memory
+	config, format, decodeErr := image.DecodeConfig(bytes.NewReader(data))
+	if decodeErr != nil {
+		verr = ValidationErrors{{"image", "must be a PNG, JPEG or GIF image", ValidationCodeInvalidFormat}}
+		return
+	}
+	verr.add(validateImageDimensions(config.Width, config.Height))
+	if verr != nil {
+		return
+	}
+	decoded, _, decodeErr := image.Decode(bytes.NewReader(data))
+	if decodeErr != nil {
+		verr = ValidationErrors{{"image", "is corrupted: " + decodeErr.Error(), ValidationCodeInvalidFormat}}
+		return
+	}
+	src := image.NewRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+	// cameras store photos as shot and record how to turn them upright, which is lost when encoding again
+	if format == "jpeg" {
+		src = orientImage(src, jpegOrientation(data))
+	}
+	var stored []string
+	// the stored variants cannot be removed by anyone else until they are referenced
+	dbp.blobLock.RLock()
+	locked := true
+	unlock := func() {
+		if locked {
+			dbp.blobLock.RUnlock()
+			locked = false
+		}
+	}
+	defer func() {
+		unlock()
+		if err != nil {
+			for _, key := range stored {
+				dbp.deleteUnusedBlob(key)
+			}
+		}
+	}()
+	ts := time.Now().Unix()
+	for _, size := range imageVariantSizes {
+		width, height := fitImage(src.Bounds().Dx(), src.Bounds().Dy(), int(size))
+		scaled := resizeImage(src, width, height)
+		var buf bytes.Buffer
+		mimeType := "image/png"
+		// JPEG is kept for photographs, everything else is saved as PNG to keep transparency
+		if format == "jpeg" {
+			mimeType = "image/jpeg"
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, scaled)
+		}
+		if err != nil {
+			return
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		key := hex.EncodeToString(sum[:])
+		var put bool
+		put, err = dbp.putBlob(key, &buf)
+		if err != nil {
+			return
+		}
+		if put {
+			stored = append(stored, key)
+		}
+		variant := &ImageVariant{
+			EntityType: entityType,
+			EntityId:   id,
+			Size:       size,
+			Width:      int64(scaled.Bounds().Dx()),
+			Height:     int64(scaled.Bounds().Dy()),
+			MimeType:   mimeType,
+			BlobKey:    key,
+			CreatedBy:  updatedBy,
+			CreatedAt:  ts,
+		}
+		dbp.imageSetLocation(variant)
+		variants = append(variants, variant)
+	}
+	old, err := dbp.imageGetVariants(entityType, id)
+	if err != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = txExec(tx, "DELETE FROM image_variant WHERE entity_type=? AND entity_id=?", entityType, id)
+	if err != nil {
+		return
+	}
+	for _, variant := range variants {
+		var result sql.Result
+		result, err = tx.Exec("INSERT INTO image_variant(entity_type,entity_id,size,width,height,mime_type,blob_key,created_by,created_at) VALUES(?,?,?,?,?,?,?,?,?)", variant.EntityType, variant.EntityId, variant.Size, variant.Width, variant.Height, variant.MimeType, variant.BlobKey, variant.CreatedBy, variant.CreatedAt)
+		if err != nil {
+			return
+		}
+		variant.Id, err = result.LastInsertId()
+		if err != nil {
+			return
+		}
+	}
+	// target comes from imageTargets, never from the caller
+	_, err = txExec(tx, "UPDATE "+target.table+" SET "+target.column+"=?,updated_by=?,updated_at=? WHERE id=?", variants[len(variants)-1].URL, updatedBy, ts, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	unlock()
+	// the image replaces any resource the field pointed to
+	_, err = dbp.assetRemove(entityType, id, target.column)
+	if err != nil {
+		return
+	}
+	for _, variant := range old {
+		if err = dbp.deleteUnusedBlob(variant.BlobKey); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// imageGetVariants returns the variants of the image of an entity, smallest first
+func (dbp *DBProvider) imageGetVariants(entityType string, id int64) (variants []*ImageVariant, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM image_variant WHERE entity_type=? AND entity_id=? ORDER BY size ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(entityType, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ImageVariant{}
+		err = rows.Scan(&p.Id, &p.EntityType, &p.EntityId, &p.Size, &p.Width, &p.Height, &p.MimeType, &p.BlobKey, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		dbp.imageSetLocation(&p)
+		variants = append(variants, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// imageDeleteAll removes the image variants of a deleted entity, which no foreign key removes
+func (dbp *DBProvider) imageDeleteAll(entityType string, id int64) (err error) {
+	variants, err := dbp.imageGetVariants(entityType, id)
+	if err != nil || len(variants) == 0 {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM image_variant WHERE entity_type=? AND entity_id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(entityType, id)
+	if err != nil {
+		return
+	}
+	for _, variant := range variants {
+		if err = dbp.deleteUnusedBlob(variant.BlobKey); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// imageSetLocation fills the URL of the variant and its Path when the blobs are stored locally
+func (dbp *DBProvider) imageSetLocation(variant *ImageVariant) {
+	ext := ".png"
+	if variant.MimeType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	variant.URL = strings.TrimSuffix(dbp.imageBaseURL, "/") + "/" + variant.BlobKey + ext
+	if local, ok := dbp.blobs.(*LocalBlobStore); ok {
+		variant.Path, _ = local.Path(variant.BlobKey)
+	}
+}
+
+func (dbp *DBProvider) ImageVariantGetColumns() []string {
+	return []string{"id", "entity_type", "entity_id", "size", "width", "height", "mime_type", "blob_key", "created_by", "created_at"}
+}
+
+// fitImage returns the dimensions of a width x height image scaled down to fit in a square of size pixels
+func fitImage(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, maxInt(1, (height*size+width/2)/width)
+	}
+	return maxInt(1, (width*size+height/2)/height), size
+}
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// resizeImage scales src down to width x height averaging the source pixels under every target pixel
+func resizeImage(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if width == sw && height == sh {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += uint64(src.Pix[i])
+					sum[1] += uint64(src.Pix[i+1])
+					sum[2] += uint64(src.Pix[i+2])
+					sum[3] += uint64(src.Pix[i+3])
+					i += 4
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			j := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[j+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, from 1 to 8, or 1 if it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		length := int(data[i+2])<<8 | int(data[i+3])
+		// the image data starts after SOS, there is no metadata past it
+		if marker == 0xda || length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the TIFF structure of EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orientImage flips and rotates src as the EXIF orientation says to display it upright
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			i, j := src.PixOffset(sx, sy), dst.PixOffset(x, y)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+func validateImageDimensions(width, height int) *ValidationError {
+	if width < ImageMinDimension || height < ImageMinDimension {
+		return &ValidationError{"image", fmt.Sprintf("cannot be smaller than %dx%d pixels", ImageMinDimension, ImageMinDimension), ValidationCodeOutOfRange}
+	}
+	if width > ImageMaxDimension || height > ImageMaxDimension {
+		return &ValidationError{"image", fmt.Sprintf("cannot be larger than %dx%d pixels", ImageMaxDimension, ImageMaxDimension), ValidationCodeOutOfRange}
+	}
+	return nil
+}
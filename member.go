@@ -1,6 +1,8 @@
 package instantolib
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -21,6 +23,9 @@ type Member struct {
 	CreatedAt                           int64  `json:"created_at"`
 	UpdatedAt                           int64  `json:"updated_at"`
 	PrimaryStatus                       int64  `json:"primary_status"`
+	Orcid                               string `json:"orcid"`
+	ScopusId                            string `json:"scopus_id"`
+	ResearcherId                        string `json:"researcher_id"`
 	RelStatusCreatedBy                  string `json:"status_created_by,omitempty"`
 	RelStatusCreatedAt                  int64  `json:"status_created_at,omitempty"`
 	RelPartnerCreatedBy                 string `json:"partner_created_by,omitempty"`
@@ -35,8 +40,8 @@ type Member struct {
 	RelFinancedProjectCreatedAt         int64  `json:"financed_project_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) MemberCreate(firstName, lastName, degree string, yearIn, yearOut int64, email, createdBy string, primaryStatus int64) (id int64, verr *ValidationError, err error) {
-	verr = memberValidate(firstName, lastName, degree, yearIn, yearOut, email)
+func (dbp *DBProvider) MemberCreate(firstName, lastName, degree string, yearIn, yearOut int64, email, createdBy string, primaryStatus int64) (id int64, verr ValidationErrors, err error) {
+	verr = MemberValidate(firstName, lastName, degree, yearIn, yearOut, email)
 	if verr != nil {
 		return
 	}
@@ -45,8 +50,17 @@ func (dbp *DBProvider) MemberCreate(firstName, lastName, degree string, yearIn,
 		return
 	}
 	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 	query := "INSERT INTO member(first_name,last_name,degree,year_in,year_out,email,created_by,updated_by,created_at,updated_at,primary_status) VALUES(?,?,?,?,?,?,?,?,?,?,?)"
-	stmt, err := db.Prepare(query)
+	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return
 	}
@@ -55,7 +69,8 @@ func (dbp *DBProvider) MemberCreate(firstName, lastName, degree string, yearIn,
 	result, err := stmt.Exec(firstName, lastName, degree, yearIn, yearOut, email, createdBy, createdBy, ts, ts, primaryStatus)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			tx.Rollback()
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -65,10 +80,21 @@ func (dbp *DBProvider) MemberCreate(firstName, lastName, degree string, yearIn,
 	if err != nil {
 		return
 	}
+	err = memberOpenStatus(tx, id, primaryStatus, createdBy, ts)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
 	return
 }
-func (dbp *DBProvider) MemberUpdate(id int64, firstName, lastName, degree string, yearIn, yearOut int64, email, updatedBy string, primaryStatus int64) (numRows int64, verr *ValidationError, err error) {
-	verr = memberValidate(firstName, lastName, degree, yearIn, yearOut, email)
+
+// MemberUpdate updates the member and, when the primary status changes, closes the period of the
+// previous primary status and opens a new one in the status history.
+func (dbp *DBProvider) MemberUpdate(id int64, firstName, lastName, degree string, yearIn, yearOut int64, email, updatedBy string, primaryStatus int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = MemberValidate(firstName, lastName, degree, yearIn, yearOut, email)
 	if verr != nil {
 		return
 	}
@@ -77,28 +103,56 @@ func (dbp *DBProvider) MemberUpdate(id int64, firstName, lastName, degree string
 		return
 	}
 	defer db.Close()
-	query := "UPDATE member SET first_name=?,last_name=?,degree=?,year_in=?,year_out=?,email=?,updated_by=?,updated_at=?,primary_status=? WHERE id=?"
-	stmt, err := db.Prepare(query)
+	tx, err := db.Begin()
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var previousStatus int64
+	err = tx.QueryRow("SELECT primary_status FROM member WHERE id=? FOR UPDATE", id).Scan(&previousStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			err = nil
+		}
+		return
+	}
+	query := "UPDATE member SET first_name=?,last_name=?,degree=?,year_in=?,year_out=?,email=?,updated_by=?,updated_at=?,primary_status=? WHERE id=?"
 	ts := time.Now().Unix()
-	result, err := stmt.Exec(firstName, lastName, degree, yearIn, yearOut, email, updatedBy, ts, primaryStatus, id)
+	numRows, err = txExec(tx, query, firstName, lastName, degree, yearIn, yearOut, email, updatedBy, ts, primaryStatus, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			tx.Rollback()
+			numRows = 0
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
 		return
 	}
-	numRows, err = result.RowsAffected()
+	if previousStatus != primaryStatus {
+		err = memberCloseStatus(tx, id, ts)
+		if err != nil {
+			return
+		}
+		err = memberOpenStatus(tx, id, primaryStatus, updatedBy, ts)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
 	if err != nil {
 		return
 	}
 	return
 }
+
+// MemberUpdateCv sets the CV to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) MemberUpdateCv(id int64, cv string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -115,7 +169,7 @@ func (dbp *DBProvider) MemberUpdateCv(id int64, cv string, updatedBy string) (nu
 	result, err := stmt.Exec(cv, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -125,8 +179,14 @@ func (dbp *DBProvider) MemberUpdateCv(id int64, cv string, updatedBy string) (nu
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypeMember, id, AssetFieldCv)
+	}
 	return
 }
+
+// MemberUpdatePhoto sets the photo to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) MemberUpdatePhoto(id int64, photo string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -143,7 +203,48 @@ func (dbp *DBProvider) MemberUpdatePhoto(id int64, photo string, updatedBy strin
 	result, err := stmt.Exec(photo, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
+			err = nil
+			return
+		}
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypeMember, id, AssetFieldPhoto)
+	}
+	return
+}
+
+// MemberUpdateIdentifiers sets the ORCID iD, Scopus Author ID and ResearcherID of a member.
+// Each identifier is unique across members and can be left empty.
+func (dbp *DBProvider) MemberUpdateIdentifiers(id int64, orcid, scopusId, researcherId, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	orcid = NormalizeOrcid(orcid)
+	scopusId = strings.TrimSpace(scopusId)
+	researcherId = strings.ToUpper(strings.TrimSpace(researcherId))
+	verr = MemberValidateIdentifiers(orcid, scopusId, researcherId)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE member SET orcid=?,scopus_id=?,researcher_id=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(nullIfEmpty(orcid), nullIfEmpty(scopusId), nullIfEmpty(researcherId), updatedBy, ts, id)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = ValidationErrors{{dbError1062Field(err, "orcid", "scopus_id", "researcher_id"), "this identifier is already used by another member", ValidationCodeDuplicate}}
 			err = nil
 			return
 		}
@@ -175,6 +276,196 @@ func (dbp *DBProvider) MemberDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.categoryUntagAll(EntityTypeMember, id)
+		if err != nil {
+			return
+		}
+		err = dbp.imageDeleteAll(EntityTypeMember, id)
+		if err != nil {
+			return
+		}
+		err = dbp.assetDeleteAll(EntityTypeMember, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeMember, id)
+	}
+	return
+}
+func (dbp *DBProvider) MemberDeletePreview(id int64) (preview *DeletePreview, err error) {
+	exists, err := dbp.MemberExists(id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = sql.ErrNoRows
+		return
+	}
+	specs := []deletePreviewSpec{
+		{"statuses", "member_status", DeletePreviewDetached, "status", "member"},
+		{"status_history", "member_status_history", DeletePreviewDetached, "status", "member"},
+		{"partners", "partner_member", DeletePreviewDetached, "partner", "member"},
+		{"publications", "member_publication", DeletePreviewDetached, "publication", "member"},
+		{"publications_as_author", "publication_author", DeletePreviewDetached, "publication", "member"},
+		{"research_lines", "research_line_member", DeletePreviewDetached, "research_line", "member"},
+		{"financed_project_participations", "financed_project_participant", DeletePreviewDetached, "financed_project", "member"},
+		{"publications_as_primary_author", "publication", DeletePreviewDependent, "id", "primary_author"},
+		{"student_works_as_author", "student_work", DeletePreviewDependent, "id", "author"},
+		{"financed_projects_as_primary_leader", "financed_project", DeletePreviewDependent, "id", "primary_leader"},
+	}
+	preview, err = dbp.deletePreview(id, specs)
+	if err != nil {
+		return
+	}
+	err = dbp.deletePreviewAttachments(preview, EntityTypeMember, id)
+	return
+}
+
+// MemberMerge moves every relation of the member dropId to the member keepId and deletes dropId.
+// Relations that keepId already has are skipped. Identifiers, CV and photo keepId does not have are taken from dropId.
+func (dbp *DBProvider) MemberMerge(keepId, dropId int64, updatedBy string) (report *MergeReport, verr *ValidationError, err error) {
+	if keepId == dropId {
+		verr = &ValidationError{"member", "cannot merge a member with itself", ValidationCodeConflict}
+		return
+	}
+	keep, err := dbp.MemberGetById(keepId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"keep", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	drop, err := dbp.MemberGetById(dropId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"drop", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	report = &MergeReport{KeepId: keepId, DropId: dropId}
+	ts := time.Now().Unix()
+	columns := []struct{ name, table, column string }{
+		{"publications_as_primary_author", "publication", "primary_author"},
+		{"student_works_as_author", "student_work", "author"},
+		{"financed_projects_as_primary_leader", "financed_project", "primary_leader"},
+	}
+	for _, c := range columns {
+		var rel *MergeRelation
+		rel, err = mergeColumnRelation(tx, c.name, c.table, c.column, keepId, dropId, updatedBy, ts)
+		if err != nil {
+			return
+		}
+		report.add(rel)
+	}
+	participations, err := mergeParticipations(tx, keepId, dropId, updatedBy, ts)
+	if err != nil {
+		return
+	}
+	report.add(participations)
+	// a status that is already primary for keepId cannot be added again as secondary
+	skippedStatus, err := txExec(tx, "DELETE FROM member_status WHERE member=? AND status=?", dropId, keep.PrimaryStatus)
+	if err != nil {
+		return
+	}
+	joins := []struct{ name, table, other string }{
+		{"statuses", "member_status", "status"},
+		{"partners", "partner_member", "partner"},
+		{"publications", "member_publication", "publication"},
+		{"publication_authors", "publication_author", "publication"},
+		{"research_lines", "research_line_member", "research_line"},
+	}
+	// the current status period of dropId is closed so keepId keeps a single current primary status
+	err = memberCloseStatus(tx, dropId, ts)
+	if err != nil {
+		return
+	}
+	// periods of dropId that overlap the history of keepId would give it two primary statuses at once
+	history := &MergeRelation{Name: "status_history", Table: "member_status_history"}
+	history.Skipped, err = txExec(tx, "DELETE FROM member_status_history WHERE id IN (SELECT id FROM (SELECT dropped.id FROM member_status_history AS dropped INNER JOIN member_status_history AS kept ON kept.member=? WHERE dropped.member=? AND (kept.ended=0 OR dropped.started<kept.ended) AND (dropped.ended=0 OR dropped.ended>kept.started)) AS overlapping)", keepId, dropId)
+	if err != nil {
+		return
+	}
+	history.Moved, err = txExec(tx, "UPDATE member_status_history SET member=? WHERE member=?", keepId, dropId)
+	if err != nil {
+		return
+	}
+	report.add(history)
+	for _, j := range joins {
+		var rel *MergeRelation
+		rel, err = mergeJoinRelation(tx, j.name, j.table, "member", j.other, keepId, dropId)
+		if err != nil {
+			return
+		}
+		if j.table == "member_status" {
+			rel.Skipped += skippedStatus
+		}
+		report.add(rel)
+	}
+	tags, err := mergeTagRelation(tx, EntityTypeMember, keepId, dropId)
+	if err != nil {
+		return
+	}
+	report.add(tags)
+	attachments, err := mergeAttachmentRelation(tx, EntityTypeMember, keepId, dropId)
+	if err != nil {
+		return
+	}
+	report.add(attachments)
+	keepAssets := map[string]string{AssetFieldCv: keep.Cv, AssetFieldPhoto: keep.Photo}
+	dropAssets := map[string]string{AssetFieldCv: drop.Cv, AssetFieldPhoto: drop.Photo}
+	assets, err := mergeAssetRelation(tx, EntityTypeMember, keepId, dropId, keepAssets, dropAssets, updatedBy, ts)
+	if err != nil {
+		return
+	}
+	report.add(assets)
+	_, err = txExec(tx, "DELETE FROM member WHERE id=?", dropId)
+	if err != nil {
+		return
+	}
+	// identifiers keepId lacks are taken from dropId once its unique values are free
+	orcid, scopusId, researcherId := keep.Orcid, keep.ScopusId, keep.ResearcherId
+	if orcid == "" {
+		orcid = drop.Orcid
+	}
+	if scopusId == "" {
+		scopusId = drop.ScopusId
+	}
+	if researcherId == "" {
+		researcherId = drop.ResearcherId
+	}
+	if orcid != keep.Orcid || scopusId != keep.ScopusId || researcherId != keep.ResearcherId {
+		_, err = txExec(tx, "UPDATE member SET orcid=?,scopus_id=?,researcher_id=?,updated_by=?,updated_at=? WHERE id=?", nullIfEmpty(orcid), nullIfEmpty(scopusId), nullIfEmpty(researcherId), updatedBy, ts, keepId)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	// the photo and CV of dropId that keepId already had are released, the others were moved to keepId
+	err = dbp.imageDeleteAll(EntityTypeMember, dropId)
+	if err != nil {
+		return
+	}
+	err = dbp.assetDeleteAll(EntityTypeMember, dropId)
 	return
 }
 func (dbp *DBProvider) MemberGetAll() (members []*Member, err error) {
@@ -196,7 +487,7 @@ func (dbp *DBProvider) MemberGetAll() (members []*Member, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId))
 		if err != nil {
 			return
 		}
@@ -221,7 +512,38 @@ func (dbp *DBProvider) MemberGetById(id int64) (member *Member, err error) {
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&member.Id, &member.FirstName, &member.LastName, &member.Degree, &member.YearIn, &member.YearOut, &member.Email, &member.Cv, &member.Photo, &member.CreatedBy, &member.UpdatedBy, &member.CreatedAt, &member.UpdatedAt, &member.PrimaryStatus)
+	err = stmt.QueryRow(id).Scan(&member.Id, &member.FirstName, &member.LastName, &member.Degree, &member.YearIn, &member.YearOut, &member.Email, &member.Cv, &member.Photo, &member.CreatedBy, &member.UpdatedBy, &member.CreatedAt, &member.UpdatedAt, &member.PrimaryStatus, (*nullString)(&member.Orcid), (*nullString)(&member.ScopusId), (*nullString)(&member.ResearcherId))
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) MemberGetByOrcid(orcid string) (member *Member, err error) {
+	member, err = dbp.memberGetByIdentifier("orcid", NormalizeOrcid(orcid))
+	return
+}
+func (dbp *DBProvider) MemberGetByScopusId(scopusId string) (member *Member, err error) {
+	member, err = dbp.memberGetByIdentifier("scopus_id", strings.TrimSpace(scopusId))
+	return
+}
+func (dbp *DBProvider) MemberGetByResearcherId(researcherId string) (member *Member, err error) {
+	member, err = dbp.memberGetByIdentifier("researcher_id", strings.ToUpper(strings.TrimSpace(researcherId)))
+	return
+}
+func (dbp *DBProvider) memberGetByIdentifier(column, value string) (member *Member, err error) {
+	member = &Member{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM member WHERE " + column + "=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(value).Scan(&member.Id, &member.FirstName, &member.LastName, &member.Degree, &member.YearIn, &member.YearOut, &member.Email, &member.Cv, &member.Photo, &member.CreatedBy, &member.UpdatedBy, &member.CreatedAt, &member.UpdatedAt, &member.PrimaryStatus, (*nullString)(&member.Orcid), (*nullString)(&member.ScopusId), (*nullString)(&member.ResearcherId))
 	if err != nil {
 		return
 	}
@@ -246,7 +568,7 @@ func (dbp *DBProvider) MemberGetByPrimaryStatus(statusId int64) (members []*Memb
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId))
 		if err != nil {
 			return
 		}
@@ -277,7 +599,7 @@ func (dbp *DBProvider) MemberGetByStatus(statusId int64) (members []*Member, err
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelStatusCreatedBy, &p.RelStatusCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelStatusCreatedBy, &p.RelStatusCreatedAt)
 		if err != nil {
 			return
 		}
@@ -308,7 +630,7 @@ func (dbp *DBProvider) MemberGetByPartner(partnerId int64) (members []*Member, e
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelPartnerCreatedBy, &p.RelPartnerCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelPartnerCreatedBy, &p.RelPartnerCreatedAt)
 		if err != nil {
 			return
 		}
@@ -326,7 +648,8 @@ func (dbp *DBProvider) MemberGetByPublication(publicationId int64) (members []*M
 		return
 	}
 	defer db.Close()
-	query := "SELECT member.*,member_publication.created_by,member_publication.created_at FROM member_publication INNER JOIN member ON member_publication.member=member.id  WHERE publication=?"
+	// members in the author list of the publication come first, in author order
+	query := "SELECT member.*,member_publication.created_by,member_publication.created_at FROM member_publication INNER JOIN member ON member_publication.member=member.id LEFT JOIN publication_author ON publication_author.publication=member_publication.publication AND publication_author.member=member_publication.member WHERE member_publication.publication=? ORDER BY publication_author.position IS NULL, publication_author.position ASC"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
@@ -339,7 +662,7 @@ func (dbp *DBProvider) MemberGetByPublication(publicationId int64) (members []*M
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelPublicationCreatedBy, &p.RelPublicationCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelPublicationCreatedBy, &p.RelPublicationCreatedAt)
 		if err != nil {
 			return
 		}
@@ -371,7 +694,7 @@ func (dbp *DBProvider) MemberGetByResearchLine(researchLineId int64) (members []
 	defer rows.Close()
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
 		if err != nil {
 			return
 		}
@@ -389,23 +712,29 @@ func (dbp *DBProvider) MemberGetByFinancedProjectAsLeader(financedProjectId int6
 		return
 	}
 	defer db.Close()
-	query := "SELECT member.*,financed_project_leader.created_by,financed_project_leader.created_at FROM financed_project_leader INNER JOIN member ON financed_project_leader.member=member.id  WHERE financed_project=?"
+	query := "SELECT member.*,financed_project_participant.created_by,financed_project_participant.created_at FROM financed_project_participant INNER JOIN member ON financed_project_participant.member=member.id WHERE financed_project=? AND role IN (" + sqlPlaceholders(len(financedProjectLeaderRoles)) + ") ORDER BY financed_project_participant.id ASC"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(financedProjectId)
+	rows, err := stmt.Query(financedProjectGroupArgs(financedProjectLeaderRoles, financedProjectId)...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
+	// several participations of the same member in the same project are listed once
+	seen := make(map[int64]bool)
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelFinancedProjectAsLeaderCreatedBy, &p.RelFinancedProjectAsLeaderCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelFinancedProjectAsLeaderCreatedBy, &p.RelFinancedProjectAsLeaderCreatedAt)
 		if err != nil {
 			return
 		}
+		if seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
 		members = append(members, &p)
 	}
 	err = rows.Err()
@@ -420,20 +749,57 @@ func (dbp *DBProvider) MemberGetByFinancedProject(financedProjectId int64) (memb
 		return
 	}
 	defer db.Close()
-	query := "SELECT member.*,financed_project_member.created_by,financed_project_member.created_at FROM financed_project_member INNER JOIN member ON financed_project_member.member=member.id  WHERE financed_project=?"
+	query := "SELECT member.*,financed_project_participant.created_by,financed_project_participant.created_at FROM financed_project_participant INNER JOIN member ON financed_project_participant.member=member.id WHERE financed_project=? AND role IN (" + sqlPlaceholders(len(financedProjectMemberRoles)) + ") ORDER BY financed_project_participant.id ASC"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(financedProjectId)
+	rows, err := stmt.Query(financedProjectGroupArgs(financedProjectMemberRoles, financedProjectId)...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
+	// several participations of the same member in the same project are listed once
+	seen := make(map[int64]bool)
 	for rows.Next() {
 		p := Member{}
-		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, &p.RelFinancedProjectCreatedBy, &p.RelFinancedProjectCreatedAt)
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId), &p.RelFinancedProjectCreatedBy, &p.RelFinancedProjectCreatedAt)
+		if err != nil {
+			return
+		}
+		if seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
+		members = append(members, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) MemberGetByCategory(categoryId int64) (members []*Member, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT member.* FROM category_tag INNER JOIN member ON category_tag.entity_id=member.id WHERE category_tag.category=? AND category_tag.entity_type=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId, EntityTypeMember)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Member{}
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId))
 		if err != nil {
 			return
 		}
@@ -493,7 +859,7 @@ func (dbp *DBProvider) MemberAddStatus(id, statusId int64, createdBy string) (ve
 		return
 	}
 	if member.PrimaryStatus == statusId {
-		verr = &ValidationError{"status", "this status is already the primary"}
+		verr = &ValidationError{"status", "this status is already the primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -511,12 +877,12 @@ func (dbp *DBProvider) MemberAddStatus(id, statusId int64, createdBy string) (ve
 	_, err = stmt.Exec(id, statusId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this status has already been added"}
+			verr = &ValidationError{"member", "this status has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -570,12 +936,12 @@ func (dbp *DBProvider) MemberAddPartner(id, partnerId int64, createdBy string) (
 	_, err = stmt.Exec(partnerId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"partner", "this partner has already been added"}
+			verr = &ValidationError{"partner", "this partner has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -613,28 +979,18 @@ func (dbp *DBProvider) MemberGetPartners(id int64) (partners []*Partner, err err
 	partners, err = dbp.PartnerGetByMember(id)
 	return
 }
+
+// MemberAddPublication links a publication to a member, appending the member to its author list if it has one
 func (dbp *DBProvider) MemberAddPublication(id, publicationId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO member_publication(member,publication,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(id, publicationId, createdBy, ts)
+	err = dbp.publicationAddMember(publicationId, id, createdBy)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"publication", "this publication has already been added"}
+			verr = &ValidationError{"publication", "this publication has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -642,29 +998,10 @@ func (dbp *DBProvider) MemberAddPublication(id, publicationId int64, createdBy s
 	}
 	return
 }
+
+// MemberRemovePublication unlinks a publication from a member and removes the member from its author list
 func (dbp *DBProvider) MemberRemovePublication(id, publicationId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM member_publication WHERE member=? AND publication=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(id, publicationId)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.publicationRemoveMember(publicationId, id)
 	return
 }
 
@@ -688,12 +1025,12 @@ func (dbp *DBProvider) MemberAddResearchLine(id, researchLineId int64, createdBy
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research line has already been added"}
+			verr = &ValidationError{"research_line", "this research line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -732,57 +1069,11 @@ func (dbp *DBProvider) MemberGetResearchLines(id int64) (researchLines []*Resear
 	return
 }
 func (dbp *DBProvider) MemberAddFinancedProjectAsLeader(id, financedProjectId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO financed_project_leader(financed_project,member,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(financedProjectId, id, createdBy, ts)
-	if err != nil {
-		if IsDbError1062(err) {
-			verr = &ValidationError{"financed_project", "this financed project has already been added"}
-			err = nil
-			return
-		}
-		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
-			err = nil
-			return
-		}
-		return
-	}
+	verr, err = dbp.financedProjectAddToGroup(financedProjectId, id, FinancedProjectRoleCoPrincipalInvestigator, financedProjectLeaderRoles, &ValidationError{"financed_project", "this financed project has already been added", ValidationCodeDuplicate}, createdBy)
 	return
 }
 func (dbp *DBProvider) MemberRemoveFinancedProjectAsLeader(id, financedProjectId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM financed_project_leader WHERE financed_project=? AND member=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(financedProjectId, id)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.financedProjectRemoveFromGroup(financedProjectId, id, financedProjectLeaderRoles)
 	return
 }
 
@@ -791,57 +1082,11 @@ func (dbp *DBProvider) MemberGetFinancedProjectsAsLeader(id int64) (financedProj
 	return
 }
 func (dbp *DBProvider) MemberAddFinancedProject(id, financedProjectId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO financed_project_member(financed_project,member,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(financedProjectId, id, createdBy, ts)
-	if err != nil {
-		if IsDbError1062(err) {
-			verr = &ValidationError{"financed_project", "this financed project has already been added"}
-			err = nil
-			return
-		}
-		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
-			err = nil
-			return
-		}
-		return
-	}
+	verr, err = dbp.financedProjectAddToGroup(financedProjectId, id, FinancedProjectRoleResearcher, financedProjectMemberRoles, &ValidationError{"financed_project", "this financed project has already been added", ValidationCodeDuplicate}, createdBy)
 	return
 }
 func (dbp *DBProvider) MemberRemoveFinancedProject(id, financedProjectId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM financed_project_member WHERE financed_project=? AND member=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(financedProjectId, id)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.financedProjectRemoveFromGroup(financedProjectId, id, financedProjectMemberRoles)
 	return
 }
 
@@ -869,6 +1114,9 @@ func (dbp *DBProvider) MemberGetColumns() []string {
 		"created_at",
 		"updated_at",
 		"primary_status",
+		"orcid",
+		"scopus_id",
+		"researcher_id",
 	}
 	return columns
 }
@@ -888,38 +1136,45 @@ func memberValidateDegree(degree string) (verr *ValidationError) {
 	return validateDegree("degree", degree)
 }
 func memberValidateYearIn(yearIn int64) (verr *ValidationError) {
-	return validateIsNumber("year_in", yearIn)
-}
-func memberValidateYearOut(yearOut int64) (verr *ValidationError) {
-	return validateIsNumber("year_out", yearOut)
+	return validateYear("year_in", yearIn)
 }
-func memberValidateEmail(email string) (verr *ValidationError) {
-	return validateLength("email", email, 200)
-}
-func memberValidate(firstName, lastName, degree string, yearIn, yearOut int64, email string) (verr *ValidationError) {
-	verr = memberValidateFirstName(firstName)
-	if verr != nil {
-		return
+func memberValidateYearOut(yearIn, yearOut int64) (verr *ValidationError) {
+	// a zero year out means the member is still in the group
+	if yearOut == 0 {
+		return nil
 	}
-	verr = memberValidateLastName(lastName)
-	if verr != nil {
-		return
-	}
-	verr = memberValidateDegree(degree)
-	if verr != nil {
-		return
-	}
-	verr = memberValidateYearIn(yearIn)
-	if verr != nil {
-		return
-	}
-	verr = memberValidateYearOut(yearOut)
-	if verr != nil {
-		return
+	if verr = validateYear("year_out", yearOut); verr != nil {
+		return verr
 	}
-	verr = memberValidateEmail(email)
-	if verr != nil {
-		return
+	return validateNotBefore("year_out", yearOut, "year_in", yearIn)
+}
+func memberValidateEmail(email string) (verr *ValidationError) {
+	if verr = validateLength("email", email, 200); verr != nil {
+		return verr
 	}
+	return validateEmail("email", email)
+}
+func MemberValidate(firstName, lastName, degree string, yearIn, yearOut int64, email string) (verrs ValidationErrors) {
+	verrs.add(memberValidateFirstName(firstName))
+	verrs.add(memberValidateLastName(lastName))
+	verrs.add(memberValidateDegree(degree))
+	verrs.add(memberValidateYearIn(yearIn))
+	verrs.add(memberValidateYearOut(yearIn, yearOut))
+	verrs.add(memberValidateEmail(email))
+	return
+}
+func memberValidateOrcid(orcid string) (verr *ValidationError) {
+	return validateORCID("orcid", orcid)
+}
+func memberValidateScopusId(scopusId string) (verr *ValidationError) {
+	return validateScopusId("scopus_id", scopusId)
+}
+func memberValidateResearcherId(researcherId string) (verr *ValidationError) {
+	return validateResearcherId("researcher_id", researcherId)
+}
+func MemberValidateIdentifiers(orcid, scopusId, researcherId string) (verrs ValidationErrors) {
+	verrs.add(memberValidateOrcid(orcid))
+	verrs.add(memberValidateScopusId(scopusId))
+	verrs.add(memberValidateResearcherId(researcherId))
 	return
 }
@@ -0,0 +1,258 @@
+package instantolib
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// MemberStatusPeriod is a period in which a member had a primary status.
+// Started and Ended are unix timestamps; Ended is 0 for the current primary status.
+// A period includes Started and excludes Ended.
+type MemberStatusPeriod struct {
+	Id        int64  `json:"id"`
+	Member    int64  `json:"member"`
+	Status    int64  `json:"status"`
+	Started   int64  `json:"started"`
+	Ended     int64  `json:"ended"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// IsCurrent reports whether the period is the current primary status of the member
+func (period *MemberStatusPeriod) IsCurrent() bool {
+	return period.Ended == 0
+}
+
+func (period *MemberStatusPeriod) overlaps(started, ended int64) bool {
+	return (period.Ended == 0 || started < period.Ended) && (ended == 0 || ended > period.Started)
+}
+
+// memberOpenStatus starts a period of statusId as the current primary status of the member
+func memberOpenStatus(tx *sql.Tx, id, statusId int64, createdBy string, ts int64) (err error) {
+	_, err = txExec(tx, "INSERT INTO member_status_history(member,status,started,ended,created_by,created_at) VALUES(?,?,?,?,?,?)", id, statusId, ts, 0, createdBy, ts)
+	return
+}
+
+// memberCloseStatus ends the current primary status period of the member at ts
+func memberCloseStatus(tx *sql.Tx, id int64, ts int64) (err error) {
+	_, err = txExec(tx, "UPDATE member_status_history SET ended=? WHERE member=? AND ended=0", ts, id)
+	return
+}
+
+// MemberAddStatusPeriod records a past primary status of the member, to complete the history of members
+// created before it was kept. The period cannot overlap another period of the member.
+func (dbp *DBProvider) MemberAddStatusPeriod(id, statusId, started, ended int64, createdBy string) (periodId int64, verr ValidationErrors, err error) {
+	verr = MemberStatusPeriodValidate(started, ended)
+	if verr != nil {
+		return
+	}
+	history, err := dbp.MemberGetStatusHistory(id)
+	if err != nil {
+		return
+	}
+	for _, period := range history {
+		if period.overlaps(started, ended) {
+			verr = ValidationErrors{{"started", "overlaps another status period of the member", ValidationCodeConflict}}
+			return
+		}
+	}
+	// without a history, an open period is the current primary status of the member
+	if len(history) == 0 && ended == 0 {
+		var member *Member
+		member, err = dbp.MemberGetById(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				verr = ValidationErrors{{"member", "not exist", ValidationCodeNotFound}}
+				err = nil
+			}
+			return
+		}
+		if member.PrimaryStatus != statusId {
+			verr = ValidationErrors{{"status", "must be the primary status of the member for an open period", ValidationCodeConflict}}
+			return
+		}
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO member_status_history(member,status,started,ended,created_by,created_at) VALUES(?,?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(id, statusId, started, ended, createdBy, ts)
+	if err != nil {
+		if is, field := IsDbError1452(err); is {
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
+			err = nil
+			return
+		}
+		return
+	}
+	periodId, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MemberRemoveStatusPeriod removes a past period from the history, the current one cannot be removed
+func (dbp *DBProvider) MemberRemoveStatusPeriod(id, periodId int64) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM member_status_history WHERE id=? AND member=? AND ended<>0"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(periodId, id)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+// MemberMigrateStatusHistory opens a period of the primary status for every member without a status history,
+// started when the member was created. It is meant to run once after upgrading, as members created before the
+// history was kept have none; members with a history are skipped, so running it again is harmless.
+func (dbp *DBProvider) MemberMigrateStatusHistory() (periods int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO member_status_history(member,status,started,ended,created_by,created_at) SELECT member.id,member.primary_status,member.created_at,0,member.created_by,? FROM member WHERE NOT EXISTS(SELECT id FROM member_status_history WHERE member_status_history.member=member.id)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(time.Now().Unix())
+	if err != nil {
+		return
+	}
+	periods, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MemberGetStatusHistory returns the primary status periods of the member, oldest first
+func (dbp *DBProvider) MemberGetStatusHistory(id int64) (history []*MemberStatusPeriod, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM member_status_history WHERE member=? ORDER BY started ASC,id ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := MemberStatusPeriod{}
+		err = rows.Scan(&p.Id, &p.Member, &p.Status, &p.Started, &p.Ended, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		history = append(history, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MemberGetByStatusAt returns the members whose primary status at date was statusId
+func (dbp *DBProvider) MemberGetByStatusAt(statusId, date int64) (members []*Member, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT DISTINCT member.* FROM member_status_history INNER JOIN member ON member_status_history.member=member.id WHERE status=? AND started<=? AND (ended=0 OR ended>?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(statusId, date, date)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Member{}
+		err = rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Degree, &p.YearIn, &p.YearOut, &p.Email, &p.Cv, &p.Photo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryStatus, (*nullString)(&p.Orcid), (*nullString)(&p.ScopusId), (*nullString)(&p.ResearcherId))
+		if err != nil {
+			return
+		}
+		members = append(members, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MemberCountByStatusAt returns how many members had statusId as primary status at date
+func (dbp *DBProvider) MemberCountByStatusAt(statusId, date int64) (count int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(DISTINCT member) FROM member_status_history WHERE status=? AND started<=? AND (ended=0 OR ended>?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(statusId, date, date).Scan(&count)
+	if err != nil {
+		return
+	}
+	return
+}
+
+func (dbp *DBProvider) MemberStatusPeriodGetColumns() []string {
+	return []string{"id", "member", "status", "started", "ended", "created_by", "created_at"}
+}
+
+func MemberStatusPeriodValidate(started, ended int64) (verrs ValidationErrors) {
+	verrs.add(validateDate("started", started))
+	if ended != 0 {
+		verrs.add(validateDate("ended", ended))
+		if ended == started {
+			verrs.add(&ValidationError{"ended", "must be after started", ValidationCodeOutOfRange})
+		}
+	}
+	verrs.add(validateNotBefore("ended", ended, "started", started))
+	return
+}
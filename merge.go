@@ -0,0 +1,69 @@
+package instantolib
+
+import (
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+type MergeReport struct {
+	KeepId    int64            `json:"keep_id"`
+	DropId    int64            `json:"drop_id"`
+	Relations []*MergeRelation `json:"relations"`
+}
+
+type MergeRelation struct {
+	Name    string `json:"name"`
+	Table   string `json:"table"`
+	Moved   int64  `json:"moved"`
+	Skipped int64  `json:"skipped"`
+}
+
+func (report *MergeReport) add(rel *MergeRelation) {
+	report.Relations = append(report.Relations, rel)
+}
+
+func txExec(tx *sql.Tx, query string, args ...interface{}) (numRows int64, err error) {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(args...)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// mergeJoinRelation repoints the rows of a join table from dropId to keepId.
+// Rows that would duplicate an existing row of keepId are deleted and reported as skipped.
+func mergeJoinRelation(tx *sql.Tx, name, table, column, other string, keepId, dropId int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: name, Table: table}
+	query := "DELETE FROM " + table + " WHERE " + column + "=? AND " + other + " IN (SELECT " + other + " FROM (SELECT " + other + " FROM " + table + " WHERE " + column + "=?) AS kept)"
+	rel.Skipped, err = txExec(tx, query, dropId, keepId)
+	if err != nil {
+		return
+	}
+	query = "UPDATE " + table + " SET " + column + "=? WHERE " + column + "=?"
+	rel.Moved, err = txExec(tx, query, keepId, dropId)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// mergeColumnRelation repoints a foreign key column of an entity table from dropId to keepId
+func mergeColumnRelation(tx *sql.Tx, name, table, column string, keepId, dropId int64, updatedBy string, ts int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: name, Table: table}
+	query := "UPDATE " + table + " SET " + column + "=?,updated_by=?,updated_at=? WHERE " + column + "=?"
+	rel.Moved, err = txExec(tx, query, keepId, updatedBy, ts, dropId)
+	if err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,288 @@
+package instantolib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// DefaultCurrency is the currency of amounts stored before currencies were recorded.
+// Those amounts have no budget currency and are in whole units, not in minor units.
+const DefaultCurrency = "EUR"
+
+// currencyMinorUnits maps the supported ISO 4217 currency codes to their number of minor unit digits
+var currencyMinorUnits = map[string]int{
+	"AUD": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"MXN": 2,
+	"NOK": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// ErrNoExchangeRate is returned when an amount cannot be converted for lack of an exchange rate
+var ErrNoExchangeRate = errors.New("no exchange rate available")
+
+// Money is an amount in the minor units of an ISO 4217 currency, 1050 EUR cents is 10.50 EUR
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// NewMoney returns amount minor units of currency, DefaultCurrency if currency is empty
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{amount, strings.ToUpper(currency)}
+}
+
+// MinorUnits returns the number of decimal digits of the currency
+func (m Money) MinorUnits() int {
+	return currencyMinorUnits[m.Currency]
+}
+
+// Major returns the amount in major units, 10.5 for 1050 EUR cents
+func (m Money) Major() float64 {
+	return float64(m.Amount) / math.Pow10(m.MinorUnits())
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%.*f %s", m.MinorUnits(), m.Major(), m.Currency)
+}
+
+// BudgetMoney returns the budget of the financed project with its currency
+func (p *FinancedProject) BudgetMoney() Money {
+	return budgetMoney(p.Budget, p.BudgetCurrency)
+}
+
+// budgetMoney returns an amount of a budget in currency, a whole amount of DefaultCurrency if currency is empty
+func budgetMoney(amount int64, currency string) Money {
+	if currency == "" {
+		m := NewMoney(0, DefaultCurrency)
+		m.Amount = amount * int64(math.Pow10(m.MinorUnits()))
+		return m
+	}
+	return NewMoney(amount, currency)
+}
+
+// convert returns m in currency, factor being the value in currency of one major unit of the currency of m
+func (m Money) convert(currency string, factor float64) (converted Money) {
+	converted = NewMoney(0, currency)
+	major := m.Major() * factor
+	converted.Amount = int64(math.Floor(major*math.Pow10(converted.MinorUnits()) + 0.5))
+	return
+}
+
+// ExchangeRate is the value in BaseCurrency of one major unit of Currency from Date on
+type ExchangeRate struct {
+	Id           int64   `json:"id"`
+	Currency     string  `json:"currency"`
+	BaseCurrency string  `json:"base_currency"`
+	Rate         float64 `json:"rate"`
+	Date         int64   `json:"date"`
+	CreatedBy    string  `json:"created_by"`
+	CreatedAt    int64   `json:"created_at"`
+}
+
+func (dbp *DBProvider) ExchangeRateCreate(currency, baseCurrency string, rate float64, date int64, createdBy string) (id int64, verr ValidationErrors, err error) {
+	currency, baseCurrency = strings.ToUpper(currency), strings.ToUpper(baseCurrency)
+	verr = ExchangeRateValidate(currency, baseCurrency, rate, date)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO exchange_rate(currency,base_currency,rate,date,created_by,created_at) VALUES(?,?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(currency, baseCurrency, rate, date, createdBy, ts)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = ValidationErrors{{"date", "there is already a rate for this date", ValidationCodeDuplicate}}
+			err = nil
+			return
+		}
+		return
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ExchangeRateDelete(id int64) (numRows int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM exchange_rate WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(id)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ExchangeRateGetAll() (rates []*ExchangeRate, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM exchange_rate ORDER BY currency ASC,base_currency ASC,date ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ExchangeRate{}
+		err = rows.Scan(&p.Id, &p.Currency, &p.BaseCurrency, &p.Rate, &p.Date, &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		rates = append(rates, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ExchangeRateGetAt returns the latest rate from currency to baseCurrency dated on or before date
+func (dbp *DBProvider) ExchangeRateGetAt(currency, baseCurrency string, date int64) (rate *ExchangeRate, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM exchange_rate WHERE currency=? AND base_currency=? AND date<=? ORDER BY date DESC LIMIT 1"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rate = &ExchangeRate{}
+	err = stmt.QueryRow(strings.ToUpper(currency), strings.ToUpper(baseCurrency), date).Scan(&rate.Id, &rate.Currency, &rate.BaseCurrency, &rate.Rate, &rate.Date, &rate.CreatedBy, &rate.CreatedAt)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// MoneyConvert converts m to currency with the exchange rate in force at date.
+// A stored rate from currency to the currency of m is used inverted when there is no direct one.
+func (dbp *DBProvider) MoneyConvert(m Money, currency string, date int64) (converted Money, err error) {
+	converted = NewMoney(0, currency)
+	if m.Currency == converted.Currency {
+		converted.Amount = m.Amount
+		return
+	}
+	var factor float64
+	rate, err := dbp.ExchangeRateGetAt(m.Currency, converted.Currency, date)
+	switch {
+	case err == nil:
+		factor = rate.Rate
+	case err == sql.ErrNoRows:
+		rate, err = dbp.ExchangeRateGetAt(converted.Currency, m.Currency, date)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrNoExchangeRate
+			}
+			return
+		}
+		factor = 1 / rate.Rate
+	default:
+		return
+	}
+	converted = m.convert(converted.Currency, factor)
+	return
+}
+
+// exchangeFactor is MoneyConvert for rates already loaded with ExchangeRateGetAll, it returns the value in
+// currency of one major unit of from at date
+func exchangeFactor(rates []*ExchangeRate, from, currency string, date int64) (factor float64, err error) {
+	var direct, inverse *ExchangeRate
+	// rates are ordered by date, so the last one on or before date is the one in force
+	for _, rate := range rates {
+		if rate.Date > date {
+			continue
+		}
+		if rate.Currency == from && rate.BaseCurrency == currency {
+			direct = rate
+		} else if rate.Currency == currency && rate.BaseCurrency == from {
+			inverse = rate
+		}
+	}
+	switch {
+	case direct != nil:
+		factor = direct.Rate
+	case inverse != nil:
+		factor = 1 / inverse.Rate
+	default:
+		err = ErrNoExchangeRate
+	}
+	return
+}
+
+func (dbp *DBProvider) ExchangeRateGetColumns() []string {
+	return []string{"id", "currency", "base_currency", "rate", "date", "created_by", "created_at"}
+}
+
+func validateCurrency(field, currency string) *ValidationError {
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return &ValidationError{field, "must be a supported ISO 4217 currency code", ValidationCodeInvalidValue}
+	}
+	return nil
+}
+func exchangeRateValidateRate(rate float64) (verr *ValidationError) {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return &ValidationError{"rate", "must be greater than 0", ValidationCodeOutOfRange}
+	}
+	return nil
+}
+func ExchangeRateValidate(currency, baseCurrency string, rate float64, date int64) (verrs ValidationErrors) {
+	verrs.add(validateCurrency("currency", currency))
+	verrs.add(validateCurrency("base_currency", baseCurrency))
+	if currency == baseCurrency {
+		verrs.add(&ValidationError{"base_currency", "must be different from currency", ValidationCodeConflict})
+	}
+	verrs.add(exchangeRateValidateRate(rate))
+	verrs.add(validateDate("date", date))
+	return
+}
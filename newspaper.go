@@ -17,8 +17,8 @@ type Newspaper struct {
 	UpdatedAt int64  `json:"updated_at"`
 }
 
-func (dbp *DBProvider) NewspaperCreate(name, web, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = newspaperValidate(name, web)
+func (dbp *DBProvider) NewspaperCreate(name, web, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = NewspaperValidate(name, web)
 	if verr != nil {
 		return
 	}
@@ -37,7 +37,7 @@ func (dbp *DBProvider) NewspaperCreate(name, web, createdBy string) (id int64, v
 	result, err := stmt.Exec(name, web, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -49,8 +49,8 @@ func (dbp *DBProvider) NewspaperCreate(name, web, createdBy string) (id int64, v
 	}
 	return
 }
-func (dbp *DBProvider) NewspaperUpdate(id int64, name, web, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = newspaperValidate(name, web)
+func (dbp *DBProvider) NewspaperUpdate(id int64, name, web, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = NewspaperValidate(name, web)
 	if verr != nil {
 		return
 	}
@@ -69,7 +69,7 @@ func (dbp *DBProvider) NewspaperUpdate(id int64, name, web, updatedBy string) (n
 	result, err := stmt.Exec(name, web, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -82,6 +82,8 @@ func (dbp *DBProvider) NewspaperUpdate(id int64, name, web, updatedBy string) (n
 	return
 }
 
+// NewspaperUpdateLogo sets the logo to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) NewspaperUpdateLogo(id int64, logo string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -98,7 +100,7 @@ func (dbp *DBProvider) NewspaperUpdateLogo(id int64, logo string, updatedBy stri
 	result, err := stmt.Exec(logo, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -108,6 +110,9 @@ func (dbp *DBProvider) NewspaperUpdateLogo(id int64, logo string, updatedBy stri
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypeNewspaper, id, AssetFieldLogo)
+	}
 	return
 }
 
@@ -131,6 +136,17 @@ func (dbp *DBProvider) NewspaperDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.imageDeleteAll(EntityTypeNewspaper, id)
+		if err != nil {
+			return
+		}
+		err = dbp.assetDeleteAll(EntityTypeNewspaper, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeNewspaper, id)
+	}
 	return
 }
 func (dbp *DBProvider) NewspaperGetAll() (newspapers []*Newspaper, err error) {
@@ -248,19 +264,16 @@ func newspaperValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 func newspaperValidateWeb(web string) (verr *ValidationError) {
-	return validateLength("web", web, 200)
+	if verr = validateLength("web", web, 200); verr != nil {
+		return verr
+	}
+	return validateURL("web", web)
 }
 func newspaperValidateLogo(logo string) (err *ValidationError) {
 	return validateLength("logo", logo, 200)
 }
-func newspaperValidate(name, web string) (verr *ValidationError) {
-	verr = newspaperValidateName(name)
-	if verr != nil {
-		return
-	}
-	verr = newspaperValidateWeb(web)
-	if verr != nil {
-		return
-	}
+func NewspaperValidate(name, web string) (verrs ValidationErrors) {
+	verrs.add(newspaperValidateName(name))
+	verrs.add(newspaperValidateWeb(web))
 	return
 }
@@ -1,6 +1,7 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -23,8 +24,8 @@ type Partner struct {
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) PartnerCreate(name, web string, sameDepartment bool, scope string, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = partnerValidate(name, web, sameDepartment, scope)
+func (dbp *DBProvider) PartnerCreate(name, web string, sameDepartment bool, scope string, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = PartnerValidate(name, web, sameDepartment, scope)
 	if verr != nil {
 		return
 	}
@@ -43,7 +44,7 @@ func (dbp *DBProvider) PartnerCreate(name, web string, sameDepartment bool, scop
 	result, err := stmt.Exec(name, web, sameDepartment, scope, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -55,8 +56,8 @@ func (dbp *DBProvider) PartnerCreate(name, web string, sameDepartment bool, scop
 	}
 	return
 }
-func (dbp *DBProvider) PartnerUpdate(id int64, name, web string, sameDepartment bool, scope string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = partnerValidate(name, web, sameDepartment, scope)
+func (dbp *DBProvider) PartnerUpdate(id int64, name, web string, sameDepartment bool, scope string, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = PartnerValidate(name, web, sameDepartment, scope)
 	if verr != nil {
 		return
 	}
@@ -75,7 +76,7 @@ func (dbp *DBProvider) PartnerUpdate(id int64, name, web string, sameDepartment
 	result, err := stmt.Exec(name, web, sameDepartment, scope, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -87,6 +88,9 @@ func (dbp *DBProvider) PartnerUpdate(id int64, name, web string, sameDepartment
 	}
 	return
 }
+
+// PartnerUpdateLogo sets the logo to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) PartnerUpdateLogo(id int64, logo string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -103,7 +107,7 @@ func (dbp *DBProvider) PartnerUpdateLogo(id int64, logo string, updatedBy string
 	result, err := stmt.Exec(logo, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -113,6 +117,9 @@ func (dbp *DBProvider) PartnerUpdateLogo(id int64, logo string, updatedBy string
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypePartner, id, AssetFieldLogo)
+	}
 	return
 }
 func (dbp *DBProvider) PartnerDelete(id int64) (numRows int64, err error) {
@@ -135,6 +142,37 @@ func (dbp *DBProvider) PartnerDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.imageDeleteAll(EntityTypePartner, id)
+		if err != nil {
+			return
+		}
+		err = dbp.assetDeleteAll(EntityTypePartner, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypePartner, id)
+	}
+	return
+}
+func (dbp *DBProvider) PartnerDeletePreview(id int64) (preview *DeletePreview, err error) {
+	exists, err := dbp.PartnerExists(id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = sql.ErrNoRows
+		return
+	}
+	specs := []deletePreviewSpec{
+		{"members", "partner_member", DeletePreviewDetached, "member", "partner"},
+		{"research_lines", "research_line_partner", DeletePreviewDetached, "research_line", "partner"},
+	}
+	preview, err = dbp.deletePreview(id, specs)
+	if err != nil {
+		return
+	}
+	err = dbp.deletePreviewAttachments(preview, EntityTypePartner, id)
 	return
 }
 func (dbp *DBProvider) PartnerGetAll() (partners []*Partner, err error) {
@@ -306,12 +344,12 @@ func (dbp *DBProvider) PartnerAddMember(id, memberId int64, createdBy string) (v
 	_, err = stmt.Exec(id, memberId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this member has already been added"}
+			verr = &ValidationError{"member", "this member has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -366,12 +404,12 @@ func (dbp *DBProvider) PartnerAddResearchLine(id, researchLineId int64, createdB
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research line has already been added"}
+			verr = &ValidationError{"research_line", "this research line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -431,7 +469,10 @@ func partnerValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 func partnerValidateWeb(web string) (verr *ValidationError) {
-	return validateLength("web", web, 200)
+	if verr = validateLength("web", web, 200); verr != nil {
+		return verr
+	}
+	return validateURL("web", web)
 }
 func partnerValidateLogo(logo string) (verr *ValidationError) {
 	return validateLength("logo", logo, 200)
@@ -439,18 +480,9 @@ func partnerValidateLogo(logo string) (verr *ValidationError) {
 func partnerValidateScope(scope string) (verr *ValidationError) {
 	return validateScope("scope", scope)
 }
-func partnerValidate(name, web string, sameDepartment bool, scope string) (verr *ValidationError) {
-	verr = partnerValidateName(name)
-	if verr != nil {
-		return
-	}
-	verr = partnerValidateWeb(web)
-	if verr != nil {
-		return
-	}
-	verr = partnerValidateScope(scope)
-	if verr != nil {
-		return
-	}
+func PartnerValidate(name, web string, sameDepartment bool, scope string) (verrs ValidationErrors) {
+	verrs.add(partnerValidateName(name))
+	verrs.add(partnerValidateWeb(web))
+	verrs.add(partnerValidateScope(scope))
 	return
 }
@@ -1,6 +1,8 @@
 package instantolib
 
 import (
+	"database/sql"
+
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -195,6 +197,65 @@ func (dbp *DBProvider) PermissionExists(id string) (exists bool, err error) {
 	exists = true
 	return
 }
+
+// UserHasPermission checks the user is enabled and has the permission through one of the roles of its group
+func (dbp *DBProvider) UserHasPermission(username, permissionId string) (has bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(*) FROM user INNER JOIN ugroup_rol ON ugroup_rol.ugroup=user.ugroup INNER JOIN rol_permission ON rol_permission.rol=ugroup_rol.rol WHERE user.username=? AND user.enabled=1 AND rol_permission.permission=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var count int64
+	err = stmt.QueryRow(username, permissionId).Scan(&count)
+	if err != nil {
+		return
+	}
+	if count != 0 {
+		has = true
+	}
+	return
+}
+
+// builtinPermissions are the permissions the library checks, see PermissionSeed
+var builtinPermissions = []*Permission{
+	{PermissionResourceReadPrivate, "Read private resources"},
+}
+
+// PermissionSeed creates the permissions the library checks that are missing, so they can be granted to roles.
+// Existing permissions are left as they are, so it can run on every start.
+func (dbp *DBProvider) PermissionSeed() (created int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT IGNORE INTO permission(id,display_name) VALUES(?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for _, permission := range builtinPermissions {
+		var result sql.Result
+		result, err = stmt.Exec(permission.Id, permission.DisplayName)
+		if err != nil {
+			return
+		}
+		var numRows int64
+		numRows, err = result.RowsAffected()
+		if err != nil {
+			return
+		}
+		created += numRows
+	}
+	return
+}
 func (dbp *DBProvider) PermissionGetColumns() []string {
 	columns := []string{
 		"id",
@@ -1,6 +1,7 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -35,14 +36,21 @@ type Publication struct {
 	PublicationType          int64  `json:"publication_type"`
 	Publisher                int64  `json:"publisher"`
 	PrimaryAuthor            int64  `json:"primary_author"`
+	Doi                      string `json:"doi"`
 	RelMemberCreatedBy       string `json:"member_created_by,omitempty"`
 	RelMemberCreatedAt       int64  `json:"member_created_at,omitempty"`
 	RelResearchLineCreatedBy string `json:"research_line_created_by,omitempty"`
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) PublicationCreate(title string, year int64, bookTitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, createdBy string, publicationType, publisher, primaryAuthor int64) (id int64, verr *ValidationError, err error) {
-	verr = publicationValidate(title, year, bookTitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language)
+func (dbp *DBProvider) PublicationCreate(title string, year int64, bookTitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, createdBy string, publicationType, publisher, primaryAuthor int64) (id int64, verr ValidationErrors, err error) {
+	verr = PublicationValidate(title, year, bookTitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language)
+	p := &Publication{Title: title, Year: year, BookTitle: bookTitle, Chapter: chapter, City: city, Country: country, ConferenceName: conferenceName, Edition: edition, Institution: institution, Isbn: isbn, Issn: issn, Journal: journal, Language: language, Nationality: nationality, Number: number, Organization: organization, Pages: pages, School: school, Series: series, Volume: volume, PublicationType: publicationType, Publisher: publisher}
+	typeVerr, err := dbp.publicationValidateType(p)
+	if err != nil {
+		return
+	}
+	verr = append(verr, typeVerr...)
 	if verr != nil {
 		return
 	}
@@ -61,7 +69,7 @@ func (dbp *DBProvider) PublicationCreate(title string, year int64, bookTitle, ch
 	result, err := stmt.Exec(title, year, bookTitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, createdBy, createdBy, ts, ts, publicationType, publisher, primaryAuthor)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -73,8 +81,14 @@ func (dbp *DBProvider) PublicationCreate(title string, year int64, bookTitle, ch
 	}
 	return
 }
-func (dbp *DBProvider) PublicationUpdate(id int64, title string, year int64, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, updatedBy string, publicationType, publisher, primaryAuthor int64) (numRows int64, verr *ValidationError, err error) {
-	verr = publicationValidate(title, year, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language)
+func (dbp *DBProvider) PublicationUpdate(id int64, title string, year int64, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, updatedBy string, publicationType, publisher, primaryAuthor int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = PublicationValidate(title, year, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language)
+	p := &Publication{Title: title, Year: year, BookTitle: booktitle, Chapter: chapter, City: city, Country: country, ConferenceName: conferenceName, Edition: edition, Institution: institution, Isbn: isbn, Issn: issn, Journal: journal, Language: language, Nationality: nationality, Number: number, Organization: organization, Pages: pages, School: school, Series: series, Volume: volume, PublicationType: publicationType, Publisher: publisher}
+	typeVerr, err := dbp.publicationValidateType(p)
+	if err != nil {
+		return
+	}
+	verr = append(verr, typeVerr...)
 	if verr != nil {
 		return
 	}
@@ -93,7 +107,42 @@ func (dbp *DBProvider) PublicationUpdate(id int64, title string, year int64, boo
 	result, err := stmt.Exec(title, year, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language, nationality, number, organization, pages, school, series, volume, updatedBy, ts, publicationType, publisher, primaryAuthor, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
+			err = nil
+			return
+		}
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// PublicationUpdateDoi sets the DOI of a publication. DOIs are unique across publications and can be left empty.
+func (dbp *DBProvider) PublicationUpdateDoi(id int64, doi, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	doi = NormalizeDoi(doi)
+	verr = PublicationValidateDoi(doi)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE publication SET doi=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(nullIfEmpty(doi), updatedBy, ts, id)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = ValidationErrors{{"doi", "this doi is already used by another publication", ValidationCodeDuplicate}}
 			err = nil
 			return
 		}
@@ -125,6 +174,102 @@ func (dbp *DBProvider) PublicationDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.categoryUntagAll(EntityTypePublication, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypePublication, id)
+	}
+	return
+}
+
+// PublicationMerge unions the members and research lines of dropId into keepId, appends the authors of dropId
+// missing from keepId to its author list and deletes dropId.
+// Fields empty in keepId, or shorter than in dropId, take the value of dropId.
+func (dbp *DBProvider) PublicationMerge(keepId, dropId int64, updatedBy string) (report *MergeReport, verr *ValidationError, err error) {
+	if keepId == dropId {
+		verr = &ValidationError{"publication", "cannot merge a publication with itself", ValidationCodeConflict}
+		return
+	}
+	keep, err := dbp.PublicationGetById(keepId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"keep", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	drop, err := dbp.PublicationGetById(dropId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"drop", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	p := mergePublicationFields(keep, drop)
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	report = &MergeReport{KeepId: keepId, DropId: dropId}
+	ts := time.Now().Unix()
+	authors, err := mergePublicationAuthors(tx, keepId, dropId, updatedBy, ts)
+	if err != nil {
+		return
+	}
+	report.add(authors)
+	joins := []struct{ name, table, other string }{
+		{"members", "member_publication", "member"},
+		{"research_lines", "research_line_publication", "research_line"},
+	}
+	for _, j := range joins {
+		var rel *MergeRelation
+		rel, err = mergeJoinRelation(tx, j.name, j.table, "publication", j.other, keepId, dropId)
+		if err != nil {
+			return
+		}
+		report.add(rel)
+	}
+	tags, err := mergeTagRelation(tx, EntityTypePublication, keepId, dropId)
+	if err != nil {
+		return
+	}
+	report.add(tags)
+	attachments, err := mergeAttachmentRelation(tx, EntityTypePublication, keepId, dropId)
+	if err != nil {
+		return
+	}
+	report.add(attachments)
+	// drop is deleted first so its unique doi can move to keep
+	_, err = txExec(tx, "DELETE FROM publication WHERE id=?", dropId)
+	if err != nil {
+		return
+	}
+	query := "UPDATE publication SET title=?,year=?,book_title=?,chapter=?,city=?,country=?,conference_name=?,edition=?,institution=?,isbn=?,issn=?,journal=?,language=?,nationality=?,number=?,organization=?,pages=?,school=?,series=?,volume=?,updated_by=?,updated_at=?,publication_type=?,publisher=?,primary_author=?,doi=? WHERE id=?"
+	_, err = txExec(tx, query, p.Title, p.Year, p.BookTitle, p.Chapter, p.City, p.Country, p.ConferenceName, p.Edition, p.Institution, p.Isbn, p.Issn, p.Journal, p.Language, p.Nationality, p.Number, p.Organization, p.Pages, p.School, p.Series, p.Volume, updatedBy, ts, p.PublicationType, p.Publisher, p.PrimaryAuthor, nullIfEmpty(p.Doi), keepId)
+	if err != nil {
+		return
+	}
+	err = publicationSyncPrimaryAuthor(tx, keepId)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
 	return
 }
 func (dbp *DBProvider) PublicationGetAll() (publications []*Publication, err error) {
@@ -146,7 +291,7 @@ func (dbp *DBProvider) PublicationGetAll() (publications []*Publication, err err
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi))
 		if err != nil {
 			return
 		}
@@ -171,7 +316,26 @@ func (dbp *DBProvider) PublicationGetById(id int64) (publication *Publication, e
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&publication.Id, &publication.Title, &publication.Year, &publication.BookTitle, &publication.City, &publication.Chapter, &publication.Country, &publication.ConferenceName, &publication.Edition, &publication.Institution, &publication.Isbn, &publication.Issn, &publication.Journal, &publication.Language, &publication.Nationality, &publication.Number, &publication.Organization, &publication.Pages, &publication.School, &publication.Series, &publication.Volume, &publication.CreatedBy, &publication.UpdatedBy, &publication.CreatedAt, &publication.UpdatedAt, &publication.PublicationType, &publication.Publisher, &publication.PrimaryAuthor)
+	err = stmt.QueryRow(id).Scan(&publication.Id, &publication.Title, &publication.Year, &publication.BookTitle, &publication.City, &publication.Chapter, &publication.Country, &publication.ConferenceName, &publication.Edition, &publication.Institution, &publication.Isbn, &publication.Issn, &publication.Journal, &publication.Language, &publication.Nationality, &publication.Number, &publication.Organization, &publication.Pages, &publication.School, &publication.Series, &publication.Volume, &publication.CreatedBy, &publication.UpdatedBy, &publication.CreatedAt, &publication.UpdatedAt, &publication.PublicationType, &publication.Publisher, &publication.PrimaryAuthor, (*nullString)(&publication.Doi))
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) PublicationGetByDoi(doi string) (publication *Publication, err error) {
+	publication = &Publication{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM publication WHERE doi=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(NormalizeDoi(doi)).Scan(&publication.Id, &publication.Title, &publication.Year, &publication.BookTitle, &publication.City, &publication.Chapter, &publication.Country, &publication.ConferenceName, &publication.Edition, &publication.Institution, &publication.Isbn, &publication.Issn, &publication.Journal, &publication.Language, &publication.Nationality, &publication.Number, &publication.Organization, &publication.Pages, &publication.School, &publication.Series, &publication.Volume, &publication.CreatedBy, &publication.UpdatedBy, &publication.CreatedAt, &publication.UpdatedAt, &publication.PublicationType, &publication.Publisher, &publication.PrimaryAuthor, (*nullString)(&publication.Doi))
 	if err != nil {
 		return
 	}
@@ -196,7 +360,7 @@ func (dbp *DBProvider) PublicationGetByPublicationType(publicationTypeId int64)
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi))
 		if err != nil {
 			return
 		}
@@ -227,7 +391,7 @@ func (dbp *DBProvider) PublicationGetByPublisher(publisherId int64) (publication
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi))
 		if err != nil {
 			return
 		}
@@ -258,7 +422,7 @@ func (dbp *DBProvider) PublicationGetByPrimaryAuthor(authorId int64) (publicatio
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi))
 		if err != nil {
 			return
 		}
@@ -289,7 +453,7 @@ func (dbp *DBProvider) PublicationGetByMember(memberId int64) (publications []*P
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, &p.RelMemberCreatedBy, &p.RelMemberCreatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi), &p.RelMemberCreatedBy, &p.RelMemberCreatedAt)
 		if err != nil {
 			return
 		}
@@ -320,7 +484,7 @@ func (dbp *DBProvider) PublicationGetByResearchLine(researchLineId int64) (publi
 	defer rows.Close()
 	for rows.Next() {
 		p := Publication{}
-		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi), &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
 		if err != nil {
 			return
 		}
@@ -333,6 +497,37 @@ func (dbp *DBProvider) PublicationGetByResearchLine(researchLineId int64) (publi
 	return
 }
 
+func (dbp *DBProvider) PublicationGetByCategory(categoryId int64) (publications []*Publication, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT publication.* FROM category_tag INNER JOIN publication ON category_tag.entity_id=publication.id WHERE category_tag.category=? AND category_tag.entity_type=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId, EntityTypePublication)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Publication{}
+		err = rows.Scan(&p.Id, &p.Title, &p.Year, &p.BookTitle, &p.City, &p.Chapter, &p.Country, &p.ConferenceName, &p.Edition, &p.Institution, &p.Isbn, &p.Issn, &p.Journal, &p.Language, &p.Nationality, &p.Number, &p.Organization, &p.Pages, &p.School, &p.Series, &p.Volume, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PublicationType, &p.Publisher, &p.PrimaryAuthor, (*nullString)(&p.Doi))
+		if err != nil {
+			return
+		}
+		publications = append(publications, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) PublicationCount() (count int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -374,28 +569,18 @@ func (dbp *DBProvider) PublicationExists(id int64) (exists bool, err error) {
 	exists = true
 	return
 }
+
+// PublicationAddMember links a member to a publication, appending it to the author list if the publication has one
 func (dbp *DBProvider) PublicationAddMember(id, memberId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "INSERT INTO member_publication(member,publication,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(memberId, id, createdBy, ts)
+	err = dbp.publicationAddMember(id, memberId, createdBy)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this member has already been added"}
+			verr = &ValidationError{"member", "this member has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -403,29 +588,10 @@ func (dbp *DBProvider) PublicationAddMember(id, memberId int64, createdBy string
 	}
 	return
 }
+
+// PublicationRemoveMember unlinks a member from a publication and removes it from the author list
 func (dbp *DBProvider) PublicationRemoveMember(id, memberId int64) (removed bool, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
-		return
-	}
-	defer db.Close()
-	query := "DELETE FROM member_publication WHERE member=? AND publication=?"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(memberId, id)
-	if err != nil {
-		return
-	}
-	numRows, err := result.RowsAffected()
-	if err != nil {
-		return
-	}
-	if numRows != 0 {
-		removed = true
-	}
+	removed, err = dbp.publicationRemoveMember(id, memberId)
 	return
 }
 
@@ -449,12 +615,12 @@ func (dbp *DBProvider) PublicationAddResearchLine(id, researchLineId int64, crea
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research_line has already been added"}
+			verr = &ValidationError{"research_line", "this research_line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -513,7 +679,7 @@ func publicationValidateTitle(title string) (verr *ValidationError) {
 	return validateLength("title", title, 200)
 }
 func publicationValidateYear(year int64) (err *ValidationError) {
-	return validateIsNumber("year", year)
+	return validateYear("year", year)
 }
 func publicationValidateBooktitle(booktitle string) (verr *ValidationError) {
 	return validateLength("booktitle", booktitle, 200)
@@ -537,10 +703,16 @@ func publicationValidateInstitution(institution string) (verr *ValidationError)
 	return validateLength("institution", institution, 200)
 }
 func publicationValidateISBN(isbn string) (verr *ValidationError) {
-	return validateLength("isbn", isbn, 200)
+	if verr = validateLength("isbn", isbn, 200); verr != nil {
+		return verr
+	}
+	return validateISBN("isbn", isbn)
 }
 func publicationValidateISSN(issn string) (verr *ValidationError) {
-	return validateLength("issn", issn, 200)
+	if verr = validateLength("issn", issn, 200); verr != nil {
+		return verr
+	}
+	return validateISSN("issn", issn)
 }
 func publicationValidateJournal(journal string) (verr *ValidationError) {
 	return validateLength("journal", journal, 200)
@@ -549,45 +721,44 @@ func publicationValidateLanguage(language string) (verr *ValidationError) {
 	return validateLength("language", language, 200)
 }
 
-func publicationValidate(title string, year int64, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language string) (verr *ValidationError) {
-	if verr = publicationValidateTitle(title); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateYear(year); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateBooktitle(booktitle); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateChapter(chapter); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateCity(city); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateCountry(country); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateConferenceName(conferenceName); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateEdition(edition); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateInstitution(institution); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateISBN(isbn); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateISSN(issn); verr != nil {
-		return verr
-	}
-	if verr = publicationValidateJournal(journal); verr != nil {
-		return verr
+func PublicationValidate(title string, year int64, booktitle, chapter, city, country, conferenceName, edition, institution, isbn, issn, journal, language string) (verrs ValidationErrors) {
+	verrs.add(publicationValidateTitle(title))
+	verrs.add(publicationValidateYear(year))
+	verrs.add(publicationValidateBooktitle(booktitle))
+	verrs.add(publicationValidateChapter(chapter))
+	verrs.add(publicationValidateCity(city))
+	verrs.add(publicationValidateCountry(country))
+	verrs.add(publicationValidateConferenceName(conferenceName))
+	verrs.add(publicationValidateEdition(edition))
+	verrs.add(publicationValidateInstitution(institution))
+	verrs.add(publicationValidateISBN(isbn))
+	verrs.add(publicationValidateISSN(issn))
+	verrs.add(publicationValidateJournal(journal))
+	verrs.add(publicationValidateLanguage(language))
+	return
+}
+
+// publicationValidateType checks p against the rules of its publication type
+func (dbp *DBProvider) publicationValidateType(p *Publication) (verr ValidationErrors, err error) {
+	publicationType, err := dbp.PublicationTypeGetById(p.PublicationType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = ValidationErrors{{"publication_type", "not exist", ValidationCodeNotFound}}
+			err = nil
+		}
+		return
 	}
-	if verr = publicationValidateLanguage(language); verr != nil {
+	verr = PublicationValidateForType(p, publicationType)
+	return
+}
+
+func publicationValidateDoi(doi string) (verr *ValidationError) {
+	if verr = validateLength("doi", doi, 200); verr != nil {
 		return verr
 	}
-	return nil
+	return validateDOI("doi", doi)
+}
+func PublicationValidateDoi(doi string) (verrs ValidationErrors) {
+	verrs.add(publicationValidateDoi(doi))
+	return
 }
@@ -0,0 +1,447 @@
+package instantolib
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// PublicationAuthor is an entry of the ordered author list of a publication.
+// Member is 0 for external authors, which are identified by Name only.
+// For member authors Name is filled with the name of the member when read.
+type PublicationAuthor struct {
+	Id          int64  `json:"id"`
+	Publication int64  `json:"publication"`
+	Position    int64  `json:"position"`
+	Member      int64  `json:"member"`
+	Name        string `json:"name"`
+	CreatedBy   string `json:"created_by"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// IsExternal reports whether the author is not a member of the group
+func (author *PublicationAuthor) IsExternal() bool {
+	return author.Member == 0
+}
+
+// PublicationSetAuthors replaces the author list of a publication with authors, in that order.
+// Member authors are also linked to the publication through member_publication, members no longer
+// in the list are unlinked and the first member author becomes the primary author.
+func (dbp *DBProvider) PublicationSetAuthors(id int64, authors []*PublicationAuthor, createdBy string) (verr ValidationErrors, err error) {
+	verr = PublicationValidateAuthors(authors)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = txExec(tx, "DELETE FROM publication_author WHERE publication=?", id)
+	if err != nil {
+		return
+	}
+	ts := time.Now().Unix()
+	for i, author := range authors {
+		member := sql.NullInt64{Int64: author.Member, Valid: author.Member != 0}
+		name := ""
+		if author.IsExternal() {
+			name = strings.TrimSpace(author.Name)
+		}
+		_, err = txExec(tx, "INSERT INTO publication_author(publication,position,member,name,created_by,created_at) VALUES(?,?,?,?,?,?)", id, i+1, member, name, createdBy, ts)
+		if err != nil {
+			if is, field := IsDbError1452(err); is {
+				tx.Rollback()
+				verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
+				err = nil
+				return
+			}
+			return
+		}
+		if author.IsExternal() {
+			continue
+		}
+		_, err = txExec(tx, "INSERT IGNORE INTO member_publication(member,publication,created_by,created_at) VALUES(?,?,?,?)", author.Member, id, createdBy, ts)
+		if err != nil {
+			return
+		}
+	}
+	query := "DELETE FROM member_publication WHERE publication=?"
+	args := []interface{}{id}
+	for _, author := range authors {
+		if !author.IsExternal() {
+			args = append(args, author.Member)
+		}
+	}
+	if len(args) > 1 {
+		query += " AND member NOT IN (?" + strings.Repeat(",?", len(args)-2) + ")"
+	}
+	_, err = txExec(tx, query, args...)
+	if err != nil {
+		return
+	}
+	err = publicationSyncPrimaryAuthor(tx, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// PublicationReorderAuthors sets the positions of the authors of a publication following authorIds,
+// which must contain every author of the publication once.
+func (dbp *DBProvider) PublicationReorderAuthors(id int64, authorIds []int64) (verr *ValidationError, err error) {
+	authors, err := dbp.PublicationGetAuthors(id)
+	if err != nil {
+		return
+	}
+	current := make(map[int64]bool)
+	for _, author := range authors {
+		current[author.Id] = true
+	}
+	if len(authorIds) != len(authors) {
+		verr = &ValidationError{"authors", "must contain every author of the publication", ValidationCodeInvalidValue}
+		return
+	}
+	for _, authorId := range authorIds {
+		if !current[authorId] {
+			verr = &ValidationError{"authors", "must contain every author of the publication once", ValidationCodeInvalidValue}
+			return
+		}
+		delete(current, authorId)
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	// positions go through negative values so the (publication, position) key is never duplicated
+	for i, authorId := range authorIds {
+		_, err = txExec(tx, "UPDATE publication_author SET position=? WHERE id=? AND publication=?", -(i + 1), authorId, id)
+		if err != nil {
+			return
+		}
+	}
+	_, err = txExec(tx, "UPDATE publication_author SET position=-position WHERE publication=? AND position<0", id)
+	if err != nil {
+		return
+	}
+	err = publicationSyncPrimaryAuthor(tx, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// publicationAddMember links a member to a publication. A publication with an author list gets the member
+// appended to it; publications without one keep listing their members through member_publication only.
+func (dbp *DBProvider) publicationAddMember(id, memberId int64, createdBy string) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	ts := time.Now().Unix()
+	_, err = txExec(tx, "INSERT INTO member_publication(member,publication,created_by,created_at) VALUES(?,?,?,?)", memberId, id, createdBy, ts)
+	if err != nil {
+		return
+	}
+	query := "INSERT INTO publication_author(publication,position,member,name,created_by,created_at) SELECT ?,MAX(position)+1,?,'',?,? FROM publication_author WHERE publication=? HAVING COUNT(id)>0 AND SUM(member<=>?)=0"
+	_, err = txExec(tx, query, id, memberId, createdBy, ts, id, memberId)
+	if err != nil {
+		return
+	}
+	err = publicationSyncPrimaryAuthor(tx, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// publicationRemoveMember unlinks a member from a publication and removes it from the author list
+func (dbp *DBProvider) publicationRemoveMember(id, memberId int64) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	linked, err := txExec(tx, "DELETE FROM member_publication WHERE member=? AND publication=?", memberId, id)
+	if err != nil {
+		return
+	}
+	var position int64
+	err = tx.QueryRow("SELECT position FROM publication_author WHERE publication=? AND member=?", id, memberId).Scan(&position)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	if err == nil {
+		_, err = txExec(tx, "DELETE FROM publication_author WHERE publication=? AND member=?", id, memberId)
+		if err != nil {
+			return
+		}
+		// the authors after it move up one position, in order so no position is duplicated
+		_, err = txExec(tx, "UPDATE publication_author SET position=position-1 WHERE publication=? AND position>? ORDER BY position ASC", id, position)
+		if err != nil {
+			return
+		}
+		linked++
+	}
+	err = publicationSyncPrimaryAuthor(tx, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	removed = linked != 0
+	return
+}
+
+// publicationSyncPrimaryAuthor makes the first member of the author list the primary author of the publication.
+// Without member authors, a primary author that is no longer linked is replaced by a linked member, if any.
+func publicationSyncPrimaryAuthor(tx *sql.Tx, id int64) (err error) {
+	query := "UPDATE publication SET primary_author=(SELECT member FROM publication_author WHERE publication=? AND member IS NOT NULL ORDER BY position ASC LIMIT 1) WHERE id=? AND EXISTS(SELECT id FROM publication_author WHERE publication=? AND member IS NOT NULL)"
+	_, err = txExec(tx, query, id, id, id)
+	if err != nil {
+		return
+	}
+	query = "UPDATE publication SET primary_author=(SELECT MIN(member) FROM member_publication WHERE publication=?) WHERE id=? AND primary_author NOT IN (SELECT member FROM member_publication WHERE publication=?) AND EXISTS(SELECT member FROM member_publication WHERE publication=?)"
+	_, err = txExec(tx, query, id, id, id, id)
+	return
+}
+
+// mergePublicationAuthors appends the authors of dropId to the author list of keepId.
+// Authors keepId already lists, the same member or an external author with the same name, are deleted and reported as skipped.
+// When dropId has no author list, the members linked to it that keepId does not list are appended instead,
+// so it must run before the members of dropId are moved.
+func mergePublicationAuthors(tx *sql.Tx, keepId, dropId int64, updatedBy string, ts int64) (rel *MergeRelation, err error) {
+	rel = &MergeRelation{Name: "authors", Table: "publication_author"}
+	members := make(map[int64]bool)
+	names := make(map[string]bool)
+	var last int64
+	rows, err := tx.Query("SELECT position,member,name FROM publication_author WHERE publication=?", keepId)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var position int64
+		var member sql.NullInt64
+		var name string
+		if err = rows.Scan(&position, &member, &name); err != nil {
+			rows.Close()
+			return
+		}
+		if member.Valid {
+			members[member.Int64] = true
+		} else {
+			names[normalizeText(name)] = true
+		}
+		if position > last {
+			last = position
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+	type dropAuthor struct {
+		id     int64
+		member sql.NullInt64
+		name   string
+	}
+	var authors []dropAuthor
+	rows, err = tx.Query("SELECT id,member,name FROM publication_author WHERE publication=? ORDER BY position ASC", dropId)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var a dropAuthor
+		if err = rows.Scan(&a.id, &a.member, &a.name); err != nil {
+			rows.Close()
+			return
+		}
+		authors = append(authors, a)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+	for _, a := range authors {
+		if (a.member.Valid && members[a.member.Int64]) || (!a.member.Valid && names[normalizeText(a.name)]) {
+			_, err = txExec(tx, "DELETE FROM publication_author WHERE id=?", a.id)
+			if err != nil {
+				return
+			}
+			rel.Skipped++
+			continue
+		}
+		last++
+		_, err = txExec(tx, "UPDATE publication_author SET publication=?,position=? WHERE id=?", keepId, last, a.id)
+		if err != nil {
+			return
+		}
+		if a.member.Valid {
+			members[a.member.Int64] = true
+		} else {
+			names[normalizeText(a.name)] = true
+		}
+		rel.Moved++
+	}
+	if last == 0 || len(authors) != 0 {
+		return
+	}
+	var linked []int64
+	rows, err = tx.Query("SELECT member FROM member_publication WHERE publication=? ORDER BY member ASC", dropId)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var member int64
+		if err = rows.Scan(&member); err != nil {
+			rows.Close()
+			return
+		}
+		linked = append(linked, member)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+	for _, member := range linked {
+		if members[member] {
+			continue
+		}
+		last++
+		_, err = txExec(tx, "INSERT INTO publication_author(publication,position,member,name,created_by,created_at) VALUES(?,?,?,'',?,?)", keepId, last, member, updatedBy, ts)
+		if err != nil {
+			return
+		}
+		members[member] = true
+		rel.Moved++
+	}
+	return
+}
+
+func (dbp *DBProvider) PublicationGetAuthors(id int64) (authors []*PublicationAuthor, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT publication_author.id,publication_author.publication,publication_author.position,publication_author.member,publication_author.name,publication_author.created_by,publication_author.created_at,member.first_name,member.last_name FROM publication_author LEFT JOIN member ON publication_author.member=member.id WHERE publication=? ORDER BY position ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := PublicationAuthor{}
+		var member sql.NullInt64
+		var firstName, lastName sql.NullString
+		err = rows.Scan(&p.Id, &p.Publication, &p.Position, &member, &p.Name, &p.CreatedBy, &p.CreatedAt, &firstName, &lastName)
+		if err != nil {
+			return
+		}
+		if member.Valid {
+			p.Member = member.Int64
+			p.Name = strings.TrimSpace(firstName.String + " " + lastName.String)
+		}
+		authors = append(authors, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// PublicationGetAuthorNames returns the names of the authors of a publication in citation order
+func (dbp *DBProvider) PublicationGetAuthorNames(id int64) (names []string, err error) {
+	authors, err := dbp.PublicationGetAuthors(id)
+	if err != nil {
+		return
+	}
+	for _, author := range authors {
+		names = append(names, author.Name)
+	}
+	return
+}
+
+func publicationValidateAuthor(author *PublicationAuthor) (verr *ValidationError) {
+	if author.IsExternal() {
+		if verr = validateNotEmpty("name", strings.TrimSpace(author.Name)); verr != nil {
+			return verr
+		}
+		return validateLength("name", author.Name, 200)
+	}
+	return validateIsNumber("member", author.Member)
+}
+func PublicationValidateAuthors(authors []*PublicationAuthor) (verrs ValidationErrors) {
+	members := make(map[int64]bool)
+	for _, author := range authors {
+		verrs.add(publicationValidateAuthor(author))
+		if author.IsExternal() {
+			continue
+		}
+		if members[author.Member] {
+			verrs.add(&ValidationError{"member", "this member has already been added", ValidationCodeDuplicate})
+		}
+		members[author.Member] = true
+	}
+	return
+}
@@ -0,0 +1,300 @@
+package instantolib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PublicationDuplicateThreshold is the minimum score for two publications to be reported as duplicates
+const PublicationDuplicateThreshold = 0.85
+
+type PublicationDuplicate struct {
+	Publication *Publication `json:"publication"`
+	Duplicate   *Publication `json:"duplicate"`
+	Score       float64      `json:"score"`
+}
+
+type publicationDuplicatesByScore []*PublicationDuplicate
+
+func (d publicationDuplicatesByScore) Len() int           { return len(d) }
+func (d publicationDuplicatesByScore) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d publicationDuplicatesByScore) Less(i, j int) bool { return d[i].Score > d[j].Score }
+
+type publicationFingerprint struct {
+	publication *Publication
+	title       string
+	titleGrams  map[string]int
+	venue       string
+	isbn        string
+	issn        string
+	doi         string
+}
+
+// publicationTitlePrefixLength is the number of letters of the normalized title that block candidates together
+const publicationTitlePrefixLength = 10
+
+// PublicationFindDuplicates scores the pairs of publications that can be duplicates and returns the pairs
+// scoring at least PublicationDuplicateThreshold, best candidates first.
+func (dbp *DBProvider) PublicationFindDuplicates() (duplicates []*PublicationDuplicate, err error) {
+	publications, err := dbp.PublicationGetAll()
+	if err != nil {
+		return
+	}
+	fingerprints := make([]*publicationFingerprint, len(publications))
+	for i, p := range publications {
+		fingerprints[i] = newPublicationFingerprint(p)
+	}
+	for i, candidates := range publicationCandidates(fingerprints) {
+		for _, j := range candidates {
+			score := publicationSimilarity(fingerprints[i], fingerprints[j])
+			if score < PublicationDuplicateThreshold {
+				continue
+			}
+			duplicates = append(duplicates, &PublicationDuplicate{fingerprints[i].publication, fingerprints[j].publication, score})
+		}
+	}
+	sort.Stable(publicationDuplicatesByScore(duplicates))
+	return
+}
+
+// publicationCandidates returns for every fingerprint the later ones, in order, worth scoring against it
+func publicationCandidates(fingerprints []*publicationFingerprint) (candidates [][]int) {
+	blocks := newPublicationBlocks()
+	for _, f := range fingerprints {
+		blocks.add(f)
+	}
+	candidates = make([][]int, len(fingerprints))
+	for i, f := range fingerprints {
+		for _, j := range blocks.candidates(f) {
+			if j > i {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+	}
+	return
+}
+
+// publicationBlocks indexes fingerprints by the keys of the blocks they belong to
+type publicationBlocks struct {
+	fingerprints []*publicationFingerprint
+	blocks       map[string][]int
+}
+
+func newPublicationBlocks() *publicationBlocks {
+	return &publicationBlocks{blocks: make(map[string][]int)}
+}
+
+func (b *publicationBlocks) add(f *publicationFingerprint) {
+	i := len(b.fingerprints)
+	b.fingerprints = append(b.fingerprints, f)
+	for _, key := range publicationBlockKeys(f) {
+		b.blocks[key] = append(b.blocks[key], i)
+	}
+	key := "year:" + strconv.FormatInt(f.publication.Year, 10)
+	b.blocks[key] = append(b.blocks[key], i)
+}
+
+// candidates returns, in order, the indexes of the fingerprints worth scoring against f:
+// the ones sharing the DOI, the ISBN or the start of the title, the ones published at most a year apart
+// and the ones without year. Publications further apart in years only reach the threshold with the same
+// title, so they share its start. A publication without year is worth scoring against all of them.
+func (b *publicationBlocks) candidates(f *publicationFingerprint) (candidates []int) {
+	year := f.publication.Year
+	if year == 0 {
+		for i := range b.fingerprints {
+			candidates = append(candidates, i)
+		}
+		return
+	}
+	keys := append(publicationBlockKeys(f), "year:0")
+	for y := year - 1; y <= year+1; y++ {
+		keys = append(keys, "year:"+strconv.FormatInt(y, 10))
+	}
+	seen := make(map[int]bool)
+	for _, key := range keys {
+		for _, i := range b.blocks[key] {
+			if !seen[i] {
+				seen[i] = true
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	sort.Ints(candidates)
+	return
+}
+
+func publicationBlockKeys(f *publicationFingerprint) (keys []string) {
+	if f.doi != "" {
+		keys = append(keys, "doi:"+f.doi)
+	}
+	if f.isbn != "" {
+		keys = append(keys, "isbn:"+f.isbn)
+	}
+	if title := []rune(f.title); len(title) > publicationTitlePrefixLength {
+		keys = append(keys, "title:"+string(title[:publicationTitlePrefixLength]))
+	} else if len(title) != 0 {
+		keys = append(keys, "title:"+string(title))
+	}
+	return
+}
+
+// PublicationSimilarity returns a score between 0 and 1 telling how likely a and b are the same publication
+func PublicationSimilarity(a, b *Publication) float64 {
+	return publicationSimilarity(newPublicationFingerprint(a), newPublicationFingerprint(b))
+}
+
+func newPublicationFingerprint(p *Publication) *publicationFingerprint {
+	venue := p.Journal
+	if venue == "" {
+		venue = p.BookTitle
+	}
+	title := normalizeText(p.Title)
+	return &publicationFingerprint{
+		publication: p,
+		title:       title,
+		titleGrams:  bigrams(title),
+		venue:       normalizeText(venue),
+		isbn:        normalizeIdentifier(p.Isbn),
+		issn:        normalizeIdentifier(p.Issn),
+		doi:         NormalizeDoi(p.Doi),
+	}
+}
+
+// publicationSimilarity weights the title, year, venue and identifiers of both publications.
+// Fields that are empty in any of them do not count.
+func publicationSimilarity(a, b *publicationFingerprint) float64 {
+	if a.doi != "" && a.doi == b.doi {
+		return 1
+	}
+	if a.isbn != "" && a.isbn == b.isbn && a.title == b.title {
+		return 1
+	}
+	var score, weight float64
+	score += 0.6 * diceCoefficient(a.titleGrams, b.titleGrams)
+	weight += 0.6
+	if a.publication.Year != 0 && b.publication.Year != 0 {
+		switch diff := a.publication.Year - b.publication.Year; {
+		case diff == 0:
+			score += 0.15
+		case diff == 1 || diff == -1:
+			score += 0.075
+		}
+		weight += 0.15
+	}
+	if a.venue != "" && b.venue != "" {
+		score += 0.1 * diceCoefficient(bigrams(a.venue), bigrams(b.venue))
+		weight += 0.1
+	}
+	if a.isbn != "" && b.isbn != "" {
+		if a.isbn == b.isbn {
+			score += 0.15
+		}
+		weight += 0.15
+	} else if a.issn != "" && b.issn != "" {
+		if a.issn == b.issn {
+			score += 0.15
+		}
+		weight += 0.15
+	}
+	return score / weight
+}
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "ä", "a", "â", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ë", "e", "ê", "e",
+	"í", "i", "ì", "i", "ï", "i", "î", "i",
+	"ó", "o", "ò", "o", "ö", "o", "ô", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "ü", "u", "û", "u",
+	"ñ", "n", "ç", "c", "ß", "ss",
+)
+
+// normalizeText lowercases s, removes accents and punctuation and collapses the spaces
+func normalizeText(s string) string {
+	s = accentReplacer.Replace(strings.ToLower(s))
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+func bigrams(s string) map[string]int {
+	grams := make(map[string]int)
+	runes := []rune(s)
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])]++
+	}
+	return grams
+}
+
+func diceCoefficient(a, b map[string]int) float64 {
+	var total, common int
+	for gram, n := range a {
+		total += n
+		if m, ok := b[gram]; ok {
+			if m < n {
+				common += m
+			} else {
+				common += n
+			}
+		}
+	}
+	for _, n := range b {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(2*common) / float64(total)
+}
+
+// mergePublicationFields returns a copy of keep completed with the values of drop
+func mergePublicationFields(keep, drop *Publication) *Publication {
+	p := *keep
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&p.Title, drop.Title},
+		{&p.BookTitle, drop.BookTitle},
+		{&p.Chapter, drop.Chapter},
+		{&p.City, drop.City},
+		{&p.Country, drop.Country},
+		{&p.ConferenceName, drop.ConferenceName},
+		{&p.Edition, drop.Edition},
+		{&p.Institution, drop.Institution},
+		{&p.Isbn, drop.Isbn},
+		{&p.Issn, drop.Issn},
+		{&p.Journal, drop.Journal},
+		{&p.Language, drop.Language},
+		{&p.Nationality, drop.Nationality},
+		{&p.Number, drop.Number},
+		{&p.Organization, drop.Organization},
+		{&p.Pages, drop.Pages},
+		{&p.School, drop.School},
+		{&p.Series, drop.Series},
+		{&p.Volume, drop.Volume},
+	} {
+		if len(strings.TrimSpace(f.src)) > len(strings.TrimSpace(*f.dst)) {
+			*f.dst = f.src
+		}
+	}
+	for _, f := range []struct {
+		dst *int64
+		src int64
+	}{
+		{&p.Year, drop.Year},
+		{&p.PublicationType, drop.PublicationType},
+		{&p.Publisher, drop.Publisher},
+		{&p.PrimaryAuthor, drop.PrimaryAuthor},
+	} {
+		if *f.dst == 0 {
+			*f.dst = f.src
+		}
+	}
+	if p.Doi == "" {
+		p.Doi = drop.Doi
+	}
+	return &p
+}
@@ -0,0 +1,350 @@
+package instantolib
+
+import (
+	"database/sql"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PublicationExportFilter selects the publications of a BibTeX export. Every set criterion must match;
+// an empty filter selects every publication.
+type PublicationExportFilter struct {
+	Member       int64
+	ResearchLine int64
+	Ids          []int64
+}
+
+// bibtexKeyStopWords are skipped when taking the first word of a title for a citation key
+var bibtexKeyStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "on": true, "of": true, "in": true, "for": true, "and": true, "to": true,
+	"el": true, "la": true, "los": true, "las": true, "un": true, "una": true, "de": true, "del": true, "en": true,
+}
+
+// PublicationExportBibtex writes the publications selected by filter in BibTeX format, ordered by year and title.
+func (dbp *DBProvider) PublicationExportBibtex(w io.Writer, filter *PublicationExportFilter) (err error) {
+	publications, err := dbp.publicationFilter(filter)
+	if err != nil {
+		return
+	}
+	entries, err := dbp.PublicationBibtexEntries(publications)
+	if err != nil {
+		return
+	}
+	err = WriteBibtex(w, entries)
+	return
+}
+
+// publicationFilter returns the publications matching every criterion of filter
+func (dbp *DBProvider) publicationFilter(filter *PublicationExportFilter) (publications []*Publication, err error) {
+	if filter == nil {
+		filter = &PublicationExportFilter{}
+	}
+	var sets [][]*Publication
+	if filter.Member != 0 {
+		var set []*Publication
+		if set, err = dbp.PublicationGetByMember(filter.Member); err != nil {
+			return
+		}
+		sets = append(sets, set)
+	}
+	if filter.ResearchLine != 0 {
+		var set []*Publication
+		if set, err = dbp.PublicationGetByResearchLine(filter.ResearchLine); err != nil {
+			return
+		}
+		sets = append(sets, set)
+	}
+	if filter.Ids != nil {
+		var set []*Publication
+		for _, id := range filter.Ids {
+			var publication *Publication
+			if publication, err = dbp.PublicationGetById(id); err != nil {
+				return
+			}
+			set = append(set, publication)
+		}
+		sets = append(sets, set)
+	}
+	if len(sets) == 0 {
+		return dbp.PublicationGetAll()
+	}
+	count := make(map[int64]int)
+	for _, set := range sets {
+		for _, publication := range set {
+			count[publication.Id]++
+		}
+	}
+	for _, publication := range sets[0] {
+		if count[publication.Id] == len(sets) {
+			publications = append(publications, publication)
+			count[publication.Id] = 0
+		}
+	}
+	return
+}
+
+type publicationsByYear []*Publication
+
+func (p publicationsByYear) Len() int      { return len(p) }
+func (p publicationsByYear) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p publicationsByYear) Less(i, j int) bool {
+	if p[i].Year != p[j].Year {
+		return p[i].Year < p[j].Year
+	}
+	if p[i].Title != p[j].Title {
+		return p[i].Title < p[j].Title
+	}
+	return p[i].Id < p[j].Id
+}
+
+// PublicationBibtexEntries turns publications into BibTeX entries, ordered by year and title.
+// The entry type is the BibTeX type of the publication type, or misc if it has none.
+// Publications without an author list are credited to their primary author and linked members.
+// Citation keys are made of the last name of the first author, the year and the first word of the title,
+// such as knuth1984texbook; publications sharing a key with any other publication get their id appended to it,
+// so the key of a publication does not depend on the other publications exported with it.
+func (dbp *DBProvider) PublicationBibtexEntries(publications []*Publication) (entries []*BibtexEntry, err error) {
+	publicationTypes, err := dbp.PublicationTypeGetAll()
+	if err != nil {
+		return
+	}
+	bibtexTypes := make(map[int64]string)
+	for _, publicationType := range publicationTypes {
+		bibtexTypes[publicationType.Id] = publicationType.BibtexType
+	}
+	publishers, err := dbp.PublisherGetAll()
+	if err != nil {
+		return
+	}
+	publisherNames := make(map[int64]string)
+	for _, publisher := range publishers {
+		publisherNames[publisher.Id] = publisher.Name
+	}
+	members, err := dbp.MemberGetAll()
+	if err != nil {
+		return
+	}
+	memberNames := make(map[int64]*Member)
+	for _, member := range members {
+		memberNames[member.Id] = member
+	}
+	keys, err := dbp.publicationBibtexKeys(memberNames)
+	if err != nil {
+		return
+	}
+	sorted := make([]*Publication, len(publications))
+	copy(sorted, publications)
+	sort.Stable(publicationsByYear(sorted))
+	for _, p := range sorted {
+		var authors []*PublicationAuthor
+		authors, err = dbp.publicationBibtexAuthors(p)
+		if err != nil {
+			return
+		}
+		entry := publicationBibtexEntry(p, authors, memberNames, publisherNames[p.Publisher])
+		if key, ok := keys[p.Id]; ok {
+			entry.Key = key
+		}
+		entry.Type = bibtexTypes[p.PublicationType]
+		if entry.Type == "" {
+			entry.Type = "misc"
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// publicationBibtexAuthors returns the author list of p or, when it has none, its primary author
+// followed by the other members linked to it
+func (dbp *DBProvider) publicationBibtexAuthors(p *Publication) (authors []*PublicationAuthor, err error) {
+	authors, err = dbp.PublicationGetAuthors(p.Id)
+	if err != nil || len(authors) != 0 {
+		return
+	}
+	members, err := dbp.MemberGetByPublication(p.Id)
+	if err != nil {
+		return
+	}
+	var ids []int
+	for _, member := range members {
+		if member.Id != p.PrimaryAuthor {
+			ids = append(ids, int(member.Id))
+		}
+	}
+	sort.Ints(ids)
+	if p.PrimaryAuthor != 0 {
+		authors = append(authors, &PublicationAuthor{Publication: p.Id, Member: p.PrimaryAuthor})
+	}
+	for _, id := range ids {
+		authors = append(authors, &PublicationAuthor{Publication: p.Id, Member: int64(id)})
+	}
+	return
+}
+
+// publicationBibtexKeys returns the citation key of every publication. The first author of a publication
+// is the first of its author list or, as in publicationBibtexAuthors, its primary author or linked member.
+func (dbp *DBProvider) publicationBibtexKeys(members map[int64]*Member) (keys map[int64]string, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT publication.id,publication.year,publication.title,first.member,first.name,COALESCE(NULLIF(publication.primary_author,0),(SELECT MIN(member) FROM member_publication WHERE member_publication.publication=publication.id),0) FROM publication LEFT JOIN publication_author AS first ON first.publication=publication.id AND first.position=(SELECT MIN(position) FROM publication_author WHERE publication_author.publication=publication.id)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	keys = make(map[int64]string)
+	count := make(map[string]int)
+	for rows.Next() {
+		var id, year, fallback int64
+		var title string
+		var member sql.NullInt64
+		var name sql.NullString
+		err = rows.Scan(&id, &year, &title, &member, &name, &fallback)
+		if err != nil {
+			return
+		}
+		author := &PublicationAuthor{Member: fallback}
+		if member.Valid || name.Valid {
+			author = &PublicationAuthor{Member: member.Int64, Name: name.String}
+		}
+		lastName := ""
+		if author.Member != 0 || author.Name != "" {
+			_, lastName = bibtexAuthorName(author, members)
+		}
+		keys[id] = bibtexKey(lastName, year, title)
+		count[keys[id]]++
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	for id, key := range keys {
+		if count[key] > 1 {
+			keys[id] = key + "_" + strconv.FormatInt(id, 10)
+		}
+	}
+	return
+}
+
+// publicationBibtexEntry fills an entry with the fields of p, encoded as LaTeX
+func publicationBibtexEntry(p *Publication, authors []*PublicationAuthor, members map[int64]*Member, publisher string) (entry *BibtexEntry) {
+	var names []string
+	var firstLast string
+	for i, author := range authors {
+		first, last := bibtexAuthorName(author, members)
+		if i == 0 {
+			firstLast = last
+		}
+		// last names are braced so their words are not read as first names
+		name := "{" + EncodeLatex(last) + "}"
+		if first != "" {
+			name += ", " + EncodeLatex(first)
+		}
+		names = append(names, name)
+	}
+	year := ""
+	if p.Year != 0 {
+		year = strconv.FormatInt(p.Year, 10)
+	}
+	entry = &BibtexEntry{
+		Key: bibtexKey(firstLast, p.Year, p.Title),
+		Fields: map[string]string{
+			"author":       strings.Join(names, " and "),
+			"title":        bibtexProtectCase(p.Title),
+			"booktitle":    EncodeLatex(p.BookTitle),
+			"eventtitle":   EncodeLatex(p.ConferenceName),
+			"chapter":      EncodeLatex(p.Chapter),
+			"journal":      EncodeLatex(p.Journal),
+			"year":         year,
+			"volume":       EncodeLatex(p.Volume),
+			"number":       EncodeLatex(p.Number),
+			"series":       EncodeLatex(p.Series),
+			"edition":      EncodeLatex(p.Edition),
+			"pages":        bibtexPages(p.Pages),
+			"publisher":    EncodeLatex(publisher),
+			"organization": EncodeLatex(p.Organization),
+			"institution":  EncodeLatex(p.Institution),
+			"school":       EncodeLatex(p.School),
+			"address":      EncodeLatex(p.City),
+			"language":     EncodeLatex(p.Language),
+			"isbn":         EncodeLatex(p.Isbn),
+			"issn":         EncodeLatex(p.Issn),
+			"doi":          p.Doi,
+		},
+	}
+	return
+}
+
+// bibtexAuthorName splits the name of an author into first and last names, taking the ones of members from members
+func bibtexAuthorName(author *PublicationAuthor, members map[int64]*Member) (first, last string) {
+	if member, ok := members[author.Member]; ok && !author.IsExternal() {
+		return member.FirstName, member.LastName
+	}
+	name := strings.TrimSpace(author.Name)
+	if comma := strings.Index(name, ","); comma != -1 {
+		return strings.TrimSpace(name[comma+1:]), strings.TrimSpace(name[:comma])
+	}
+	if space := strings.LastIndex(name, " "); space != -1 {
+		return strings.TrimSpace(name[:space]), strings.TrimSpace(name[space+1:])
+	}
+	return "", name
+}
+
+// bibtexPages writes page ranges such as 10-20 with the -- BibTeX uses for them
+func bibtexPages(pages string) string {
+	pages = strings.Replace(pages, "–", "-", -1)
+	pages = strings.Replace(pages, "--", "-", -1)
+	return strings.Replace(EncodeLatex(pages), "-", "--", -1)
+}
+
+// bibtexProtectCase encodes a title, bracing the words with capitals so bibliography styles keep their case
+func bibtexProtectCase(title string) string {
+	words := strings.Fields(title)
+	for i, word := range words {
+		encoded := EncodeLatex(word)
+		if i > 0 && strings.ToLower(word) != word {
+			encoded = "{" + encoded + "}"
+		}
+		words[i] = encoded
+	}
+	return strings.Join(words, " ")
+}
+
+// bibtexKey makes a citation key out of ASCII letters and digits only, such as knuth1984texbook
+func bibtexKey(lastName string, year int64, title string) string {
+	key := bibtexKeyWord(lastName)
+	if key == "" {
+		key = "anonymous"
+	}
+	if year != 0 {
+		key += strconv.FormatInt(year, 10)
+	}
+	for _, word := range strings.Fields(normalizeText(title)) {
+		if !bibtexKeyStopWords[word] {
+			key += bibtexKeyWord(word)
+			break
+		}
+	}
+	return key
+}
+
+// bibtexKeyWord lowercases s and keeps the ASCII letters and digits of it without accents
+func bibtexKeyWord(s string) string {
+	var key []byte
+	for _, c := range []byte(normalizeText(s)) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			key = append(key, c)
+		}
+	}
+	return string(key)
+}
@@ -0,0 +1,355 @@
+package instantolib
+
+import (
+	"database/sql"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Statuses of the entries of a BibTeX import
+const (
+	BibtexImportCreated   = "created"
+	BibtexImportDuplicate = "duplicate"
+	BibtexImportInvalid   = "invalid"
+)
+
+// bibtexTypeAliases maps the BibTeX entry types that have the same meaning as a standard one
+var bibtexTypeAliases = map[string]string{
+	"conference": "inproceedings",
+}
+
+// BibtexImportResult reports what happened, or in a dry run what would happen, to an entry of a BibTeX import.
+// Publication holds the publication built from the entry, with its id once created.
+// Authors are matched to members by name; the ones not matched are external authors.
+// NewPublisher is the name of a publisher created for the entry.
+// Ignored lists the fields of the entry left out because its publication type does not allow them.
+// DuplicateOf is the existing publication a duplicate entry matches, or DuplicateOfKey the earlier entry of the same file.
+type BibtexImportResult struct {
+	Key            string               `json:"key"`
+	Line           int                  `json:"line"`
+	Status         string               `json:"status"`
+	Publication    *Publication         `json:"publication,omitempty"`
+	Authors        []*PublicationAuthor `json:"authors,omitempty"`
+	NewPublisher   string               `json:"new_publisher,omitempty"`
+	Ignored        []string             `json:"ignored,omitempty"`
+	DuplicateOf    int64                `json:"duplicate_of,omitempty"`
+	DuplicateOfKey string               `json:"duplicate_of_key,omitempty"`
+	Errors         ValidationErrors     `json:"errors,omitempty"`
+}
+
+// PublicationImportBibtex creates a publication for every entry of the BibTeX file read from r.
+// Entry types are mapped to publication types through their BibTeX type, publishers are found by
+// name or created and authors are matched to members by name, the first member author being the primary one.
+// Entries matching an existing publication, or an earlier entry, are reported as duplicates and not created.
+// Entries with a syntax error are reported as invalid and the import goes on with the next one.
+// With dryRun nothing is written and the results tell what an import would do.
+func (dbp *DBProvider) PublicationImportBibtex(r io.Reader, dryRun bool, createdBy string) (results []*BibtexImportResult, err error) {
+	entries, err := parseBibtex(r, true)
+	if err != nil {
+		return
+	}
+	imp, err := dbp.newBibtexImport(dryRun, createdBy)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.syntaxErr != nil {
+			results = append(results, &BibtexImportResult{Key: entry.Key, Line: entry.Line, Status: BibtexImportInvalid, Errors: ValidationErrors{{"entry", entry.syntaxErr.Error(), ValidationCodeInvalidFormat}}})
+			continue
+		}
+		var result *BibtexImportResult
+		result, err = imp.importEntry(entry)
+		if err != nil {
+			return
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// bibtexImport holds what an import looks up for every entry
+type bibtexImport struct {
+	dbp          *DBProvider
+	dryRun       bool
+	createdBy    string
+	types        map[string]*PublicationType
+	publishers   map[string]int64
+	members      map[string][]*Member
+	publications *publicationBlocks
+	keys         map[*Publication]string
+}
+
+func (dbp *DBProvider) newBibtexImport(dryRun bool, createdBy string) (imp *bibtexImport, err error) {
+	imp = &bibtexImport{
+		dbp:          dbp,
+		dryRun:       dryRun,
+		createdBy:    createdBy,
+		types:        make(map[string]*PublicationType),
+		publishers:   make(map[string]int64),
+		members:      make(map[string][]*Member),
+		publications: newPublicationBlocks(),
+		keys:         make(map[*Publication]string),
+	}
+	publishers, err := dbp.PublisherGetAll()
+	if err != nil {
+		return
+	}
+	for _, publisher := range publishers {
+		imp.publishers[normalizeText(publisher.Name)] = publisher.Id
+	}
+	members, err := dbp.MemberGetAll()
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		last := normalizeText(member.LastName)
+		imp.members[last] = append(imp.members[last], member)
+	}
+	publications, err := dbp.PublicationGetAll()
+	if err != nil {
+		return
+	}
+	for _, publication := range publications {
+		imp.publications.add(newPublicationFingerprint(publication))
+	}
+	return
+}
+
+func (imp *bibtexImport) importEntry(entry *BibtexEntry) (result *BibtexImportResult, err error) {
+	result = &BibtexImportResult{Key: entry.Key, Line: entry.Line, Status: BibtexImportInvalid}
+	publicationType, err := imp.publicationType(entry.Type)
+	if err != nil {
+		return
+	}
+	if publicationType == nil {
+		result.Errors = ValidationErrors{{"publication_type", "there is no publication type for @" + entry.Type, ValidationCodeNotFound}}
+		return
+	}
+	p, verr := publicationFromBibtex(entry)
+	p.PublicationType = publicationType.Id
+	result.Publication = p
+	result.Errors = append(result.Errors, verr...)
+	publisherName := entry.Field("publisher")
+	if publisherName != "" {
+		p.Publisher = imp.publishers[normalizeText(publisherName)]
+		if p.Publisher == 0 {
+			// the publisher to create counts as present for the rules of the type
+			result.NewPublisher = publisherName
+			p.Publisher = -1
+		}
+	}
+	result.Ignored = ignoreDisallowedFields(p, publicationType)
+	if result.NewPublisher != "" && p.Publisher == 0 {
+		result.NewPublisher = ""
+	}
+	if result.NewPublisher != "" {
+		result.Errors = append(result.Errors, PublisherValidate(publisherName)...)
+	}
+	result.Authors = imp.matchAuthors(entry.Names("author"))
+	for _, author := range result.Authors {
+		if !author.IsExternal() {
+			p.PrimaryAuthor = author.Member
+			break
+		}
+	}
+	result.Errors = append(result.Errors, PublicationValidate(p.Title, p.Year, p.BookTitle, p.Chapter, p.City, p.Country, p.ConferenceName, p.Edition, p.Institution, p.Isbn, p.Issn, p.Journal, p.Language)...)
+	result.Errors = append(result.Errors, PublicationValidateForType(p, publicationType)...)
+	result.Errors = append(result.Errors, PublicationValidateDoi(p.Doi)...)
+	result.Errors = append(result.Errors, PublicationValidateAuthors(result.Authors)...)
+	if result.NewPublisher != "" {
+		p.Publisher = 0
+	}
+	if len(result.Errors) != 0 {
+		return
+	}
+	fingerprint := newPublicationFingerprint(p)
+	if duplicate := imp.findDuplicate(fingerprint); duplicate != nil {
+		result.Status = BibtexImportDuplicate
+		result.DuplicateOf = duplicate.Id
+		result.DuplicateOfKey = imp.keys[duplicate]
+		return
+	}
+	if !imp.dryRun {
+		result.Errors, err = imp.create(result)
+		if err != nil || result.Errors != nil {
+			return
+		}
+	}
+	imp.publications.add(fingerprint)
+	imp.keys[p] = entry.Key
+	result.Status = BibtexImportCreated
+	return
+}
+
+// create writes the publisher, the publication and the authors of a valid entry
+func (imp *bibtexImport) create(result *BibtexImportResult) (verr ValidationErrors, err error) {
+	dbp, p := imp.dbp, result.Publication
+	if result.NewPublisher != "" {
+		p.Publisher, verr, err = dbp.PublisherCreate(result.NewPublisher, imp.createdBy)
+		if err != nil || verr != nil {
+			return
+		}
+		imp.publishers[normalizeText(result.NewPublisher)] = p.Publisher
+	}
+	p.Id, verr, err = dbp.PublicationCreate(p.Title, p.Year, p.BookTitle, p.Chapter, p.City, p.Country, p.ConferenceName, p.Edition, p.Institution, p.Isbn, p.Issn, p.Journal, p.Language, p.Nationality, p.Number, p.Organization, p.Pages, p.School, p.Series, p.Volume, imp.createdBy, p.PublicationType, p.Publisher, p.PrimaryAuthor)
+	if err != nil || verr != nil {
+		return
+	}
+	// an entry that fails after the publication is created leaves nothing behind
+	defer func() {
+		if err != nil || verr != nil {
+			dbp.PublicationDelete(p.Id)
+			p.Id = 0
+		}
+	}()
+	if p.Doi != "" {
+		_, verr, err = dbp.PublicationUpdateDoi(p.Id, p.Doi, imp.createdBy)
+		if err != nil || verr != nil {
+			return
+		}
+	}
+	if len(result.Authors) != 0 {
+		verr, err = dbp.PublicationSetAuthors(p.Id, result.Authors, imp.createdBy)
+	}
+	return
+}
+
+// publicationType returns the publication type of a BibTeX entry type, or nil if there is none
+func (imp *bibtexImport) publicationType(entryType string) (publicationType *PublicationType, err error) {
+	if alias, ok := bibtexTypeAliases[entryType]; ok {
+		entryType = alias
+	}
+	if publicationType, ok := imp.types[entryType]; ok {
+		return publicationType, nil
+	}
+	publicationType, err = imp.dbp.PublicationTypeGetByBibtexType(entryType)
+	if err != nil {
+		publicationType = nil
+		if err != sql.ErrNoRows {
+			return
+		}
+		err = nil
+	}
+	imp.types[entryType] = publicationType
+	return
+}
+
+// matchAuthors turns the names of an entry into authors, linking the names that match one member only
+func (imp *bibtexImport) matchAuthors(names []BibtexName) (authors []*PublicationAuthor) {
+	matched := make(map[int64]bool)
+	for _, name := range names {
+		author := &PublicationAuthor{Name: strings.TrimSpace(name.First + " " + name.Last)}
+		var candidates []*Member
+		for _, member := range imp.members[normalizeText(name.Last)] {
+			if firstNamesMatch(name.First, member.FirstName) && !matched[member.Id] {
+				candidates = append(candidates, member)
+			}
+		}
+		if len(candidates) == 1 {
+			author.Member = candidates[0].Id
+			matched[author.Member] = true
+		}
+		authors = append(authors, author)
+	}
+	return
+}
+
+// firstNamesMatch compares the first names of a citation and of a member, where an initial matches any name starting with it
+func firstNamesMatch(cited, first string) bool {
+	a, b := strings.Fields(normalizeText(cited)), strings.Fields(normalizeText(first))
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if len(a[i]) == 1 || len(b[i]) == 1 {
+			if a[i][0] != b[i][0] {
+				return false
+			}
+		} else if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findDuplicate returns the publication, existing or imported before, that the fingerprint duplicates
+func (imp *bibtexImport) findDuplicate(fingerprint *publicationFingerprint) *Publication {
+	for _, i := range imp.publications.candidates(fingerprint) {
+		if candidate := imp.publications.fingerprints[i]; publicationSimilarity(fingerprint, candidate) >= PublicationDuplicateThreshold {
+			return candidate.publication
+		}
+	}
+	return nil
+}
+
+// publicationFromBibtex fills a publication with the fields of a BibTeX entry
+func publicationFromBibtex(entry *BibtexEntry) (p *Publication, verr ValidationErrors) {
+	p = &Publication{
+		Title:          entry.Field("title"),
+		BookTitle:      entry.Field("booktitle"),
+		Chapter:        entry.Field("chapter"),
+		City:           entry.Field("address"),
+		ConferenceName: entry.Field("eventtitle"),
+		Edition:        entry.Field("edition"),
+		Institution:    entry.Field("institution"),
+		Isbn:           entry.Field("isbn"),
+		Issn:           entry.Field("issn"),
+		Journal:        entry.Field("journal"),
+		Language:       entry.Field("language"),
+		Number:         entry.Field("number"),
+		Organization:   entry.Field("organization"),
+		Pages:          strings.Replace(entry.Field("pages"), "–", "-", -1),
+		School:         entry.Field("school"),
+		Series:         entry.Field("series"),
+		Volume:         entry.Field("volume"),
+		Doi:            NormalizeDoi(entry.Field("doi")),
+	}
+	if year := entry.Field("year"); year != "" {
+		var err error
+		if p.Year, err = strconv.ParseInt(year, 10, 64); err != nil {
+			verr.add(&ValidationError{"year", year + " is not a year", ValidationCodeInvalidFormat})
+		}
+	}
+	return
+}
+
+// ignoreDisallowedFields empties the fields of p that publicationType does not allow and returns their names
+func ignoreDisallowedFields(p *Publication, publicationType *PublicationType) (ignored []string) {
+	for _, verr := range PublicationValidateForType(p, publicationType) {
+		if verr.Code != ValidationCodeInvalidValue {
+			continue
+		}
+		if verr.Field == "publisher" {
+			p.Publisher = 0
+		} else if field, ok := publicationStringFields(p)[verr.Field]; ok {
+			*field = ""
+		}
+		ignored = append(ignored, verr.Field)
+	}
+	return
+}
+
+// publicationStringFields maps the rule field names to the text fields of p
+func publicationStringFields(p *Publication) map[string]*string {
+	return map[string]*string{
+		"book_title":      &p.BookTitle,
+		"chapter":         &p.Chapter,
+		"city":            &p.City,
+		"conference_name": &p.ConferenceName,
+		"country":         &p.Country,
+		"edition":         &p.Edition,
+		"institution":     &p.Institution,
+		"isbn":            &p.Isbn,
+		"issn":            &p.Issn,
+		"journal":         &p.Journal,
+		"language":        &p.Language,
+		"nationality":     &p.Nationality,
+		"number":          &p.Number,
+		"organization":    &p.Organization,
+		"pages":           &p.Pages,
+		"school":          &p.School,
+		"series":          &p.Series,
+		"volume":          &p.Volume,
+	}
+}
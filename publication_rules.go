@@ -0,0 +1,114 @@
+package instantolib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// publicationFields are the publication fields a publication type can require or allow.
+// Title and year are always required and are not part of the rules.
+var publicationFields = []string{
+	"book_title",
+	"chapter",
+	"city",
+	"conference_name",
+	"country",
+	"edition",
+	"institution",
+	"isbn",
+	"issn",
+	"journal",
+	"language",
+	"nationality",
+	"number",
+	"organization",
+	"pages",
+	"publisher",
+	"school",
+	"series",
+	"volume",
+}
+
+// publicationCommonFields are allowed for every publication type
+var publicationCommonFields = []string{"country", "language", "nationality"}
+
+// standardPublicationTypes follow the required and optional fields of the standard BibTeX entry types
+var standardPublicationTypes = []*PublicationType{
+	{Name: "Article", BibtexType: "article", RequiredFields: []string{"journal"}, AllowedFields: []string{"volume", "number", "pages", "issn"}},
+	{Name: "Book", BibtexType: "book", RequiredFields: []string{"publisher"}, AllowedFields: []string{"volume", "number", "series", "city", "edition", "isbn"}},
+	{Name: "Booklet", BibtexType: "booklet", AllowedFields: []string{"city"}},
+	{Name: "Book chapter", BibtexType: "inbook", RequiredFields: []string{"chapter", "publisher"}, AllowedFields: []string{"volume", "number", "series", "city", "edition", "pages", "isbn"}},
+	{Name: "Part of a book", BibtexType: "incollection", RequiredFields: []string{"book_title", "publisher"}, AllowedFields: []string{"volume", "number", "series", "chapter", "pages", "city", "edition", "isbn"}},
+	{Name: "Conference paper", BibtexType: "inproceedings", RequiredFields: []string{"book_title"}, AllowedFields: []string{"conference_name", "volume", "number", "series", "pages", "city", "organization", "publisher", "isbn", "issn"}},
+	{Name: "Manual", BibtexType: "manual", AllowedFields: []string{"organization", "city", "edition"}},
+	{Name: "Master's thesis", BibtexType: "mastersthesis", RequiredFields: []string{"school"}, AllowedFields: []string{"city"}},
+	{Name: "Miscellaneous", BibtexType: "misc"},
+	{Name: "PhD thesis", BibtexType: "phdthesis", RequiredFields: []string{"school"}, AllowedFields: []string{"city"}},
+	{Name: "Proceedings", BibtexType: "proceedings", AllowedFields: []string{"conference_name", "volume", "number", "series", "city", "organization", "publisher", "isbn", "issn"}},
+	{Name: "Technical report", BibtexType: "techreport", RequiredFields: []string{"institution"}, AllowedFields: []string{"number", "city"}},
+	{Name: "Unpublished", BibtexType: "unpublished"},
+}
+
+func isPublicationField(field string) bool {
+	for _, f := range publicationFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// publicationFieldValues maps the rule field names to the values of p
+func publicationFieldValues(p *Publication) map[string]string {
+	publisher := ""
+	if p.Publisher != 0 {
+		publisher = strconv.FormatInt(p.Publisher, 10)
+	}
+	return map[string]string{
+		"book_title":      p.BookTitle,
+		"chapter":         p.Chapter,
+		"city":            p.City,
+		"conference_name": p.ConferenceName,
+		"country":         p.Country,
+		"edition":         p.Edition,
+		"institution":     p.Institution,
+		"isbn":            p.Isbn,
+		"issn":            p.Issn,
+		"journal":         p.Journal,
+		"language":        p.Language,
+		"nationality":     p.Nationality,
+		"number":          p.Number,
+		"organization":    p.Organization,
+		"pages":           p.Pages,
+		"publisher":       publisher,
+		"school":          p.School,
+		"series":          p.Series,
+		"volume":          p.Volume,
+	}
+}
+
+// PublicationValidateForType checks p has every field required by publicationType and,
+// when publicationType restricts them, no field outside the required and allowed ones.
+func PublicationValidateForType(p *Publication, publicationType *PublicationType) (verrs ValidationErrors) {
+	values := publicationFieldValues(p)
+	for _, field := range publicationType.RequiredFields {
+		if strings.TrimSpace(values[field]) == "" {
+			verrs.add(&ValidationError{field, "is required for " + publicationType.Name, ValidationCodeRequired})
+		}
+	}
+	if len(publicationType.AllowedFields) == 0 {
+		return
+	}
+	allowed := make(map[string]bool)
+	for _, lists := range [][]string{publicationCommonFields, publicationType.RequiredFields, publicationType.AllowedFields} {
+		for _, field := range lists {
+			allowed[field] = true
+		}
+	}
+	for _, field := range publicationFields {
+		if !allowed[field] && strings.TrimSpace(values[field]) != "" {
+			verrs.add(&ValidationError{field, "is not allowed for " + publicationType.Name, ValidationCodeInvalidValue})
+		}
+	}
+	return
+}
@@ -1,22 +1,27 @@
 package instantolib
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type PublicationType struct {
-	Id        int64  `json:"id"`
-	Name      string `json:"name"`
-	CreatedBy string `json:"created_by"`
-	UpdatedBy string `json:"updated_by"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	Id             int64    `json:"id"`
+	Name           string   `json:"name"`
+	CreatedBy      string   `json:"created_by"`
+	UpdatedBy      string   `json:"updated_by"`
+	CreatedAt      int64    `json:"created_at"`
+	UpdatedAt      int64    `json:"updated_at"`
+	BibtexType     string   `json:"bibtex_type"`
+	RequiredFields []string `json:"required_fields"`
+	AllowedFields  []string `json:"allowed_fields"`
 }
 
-func (dbp *DBProvider) PublicationTypeCreate(name, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = publicationTypeValidate(name)
+func (dbp *DBProvider) PublicationTypeCreate(name, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = PublicationTypeValidate(name)
 	if verr != nil {
 		return
 	}
@@ -35,7 +40,7 @@ func (dbp *DBProvider) PublicationTypeCreate(name, createdBy string) (id int64,
 	result, err := stmt.Exec(name, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -47,8 +52,8 @@ func (dbp *DBProvider) PublicationTypeCreate(name, createdBy string) (id int64,
 	}
 	return
 }
-func (dbp *DBProvider) PublicationTypeUpdate(id int64, name, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = publicationTypeValidate(name)
+func (dbp *DBProvider) PublicationTypeUpdate(id int64, name, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = PublicationTypeValidate(name)
 	if verr != nil {
 		return
 	}
@@ -67,7 +72,7 @@ func (dbp *DBProvider) PublicationTypeUpdate(id int64, name, updatedBy string) (
 	result, err := stmt.Exec(name, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -79,6 +84,88 @@ func (dbp *DBProvider) PublicationTypeUpdate(id int64, name, updatedBy string) (
 	}
 	return
 }
+
+// PublicationTypeUpdateRules sets the BibTeX entry type of a publication type and the publication
+// fields it requires and allows. An empty allowedFields allows every field.
+func (dbp *DBProvider) PublicationTypeUpdateRules(id int64, bibtexType string, requiredFields, allowedFields []string, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = PublicationTypeValidateRules(bibtexType, requiredFields, allowedFields)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE publication_type SET bibtex_type=?,required_fields=?,allowed_fields=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(bibtexType, strings.Join(requiredFields, ","), strings.Join(allowedFields, ","), updatedBy, ts, id)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// PublicationTypeSeed creates, in one transaction, the standard BibTeX publication types whose name or
+// BibTeX type does not exist yet and returns the ids of the created ones.
+func (dbp *DBProvider) PublicationTypeSeed(createdBy string) (ids []int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	query := "INSERT INTO publication_type(name,bibtex_type,required_fields,allowed_fields,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?)"
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	for _, std := range standardPublicationTypes {
+		var count int64
+		err = tx.QueryRow("SELECT COUNT(id) FROM publication_type WHERE name=? OR bibtex_type=?", std.Name, std.BibtexType).Scan(&count)
+		if err != nil {
+			return
+		}
+		if count != 0 {
+			continue
+		}
+		var result sql.Result
+		result, err = stmt.Exec(std.Name, std.BibtexType, strings.Join(std.RequiredFields, ","), strings.Join(std.AllowedFields, ","), createdBy, createdBy, ts, ts)
+		if err != nil {
+			return
+		}
+		var id int64
+		id, err = result.LastInsertId()
+		if err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) PublicationTypeDelete(id int64) (numRows int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -99,9 +186,12 @@ func (dbp *DBProvider) PublicationTypeDelete(id int64) (numRows int64, err error
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.translationDeleteAll(EntityTypePublicationType, id)
+	}
 	return
 }
-func (dbp *DBProvider) PublicationTypeGetAll() (publicationTypes []*PublicationType, err error) {
+func (dbp *DBProvider) PublicationTypeGetAll(opts ...QueryOption) (publicationTypes []*PublicationType, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -120,19 +210,27 @@ func (dbp *DBProvider) PublicationTypeGetAll() (publicationTypes []*PublicationT
 	defer rows.Close()
 	for rows.Next() {
 		p := PublicationType{}
-		err = rows.Scan(&p.Id, &p.Name, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		var requiredFields, allowedFields string
+		err = rows.Scan(&p.Id, &p.Name, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, (*nullString)(&p.BibtexType), (*nullString)(&requiredFields), (*nullString)(&allowedFields))
 		if err != nil {
 			return
 		}
+		p.RequiredFields = splitFields(requiredFields)
+		p.AllowedFields = splitFields(allowedFields)
 		publicationTypes = append(publicationTypes, &p)
 	}
 	err = rows.Err()
 	if err != nil {
 		return
 	}
+	items := make([]translatable, len(publicationTypes))
+	for i, p := range publicationTypes {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypePublicationType, items, opts)
 	return
 }
-func (dbp *DBProvider) PublicationTypeGetById(id int64) (publicationType *PublicationType, err error) {
+func (dbp *DBProvider) PublicationTypeGetById(id int64, opts ...QueryOption) (publicationType *PublicationType, err error) {
 	publicationType = &PublicationType{}
 	db, err := dbp.getDB()
 	if err != nil {
@@ -145,10 +243,36 @@ func (dbp *DBProvider) PublicationTypeGetById(id int64) (publicationType *Public
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&publicationType.Id, &publicationType.Name, &publicationType.CreatedBy, &publicationType.UpdatedBy, &publicationType.CreatedAt, &publicationType.UpdatedAt)
+	var requiredFields, allowedFields string
+	err = stmt.QueryRow(id).Scan(&publicationType.Id, &publicationType.Name, &publicationType.CreatedBy, &publicationType.UpdatedBy, &publicationType.CreatedAt, &publicationType.UpdatedAt, (*nullString)(&publicationType.BibtexType), (*nullString)(&requiredFields), (*nullString)(&allowedFields))
+	if err != nil {
+		return
+	}
+	publicationType.RequiredFields = splitFields(requiredFields)
+	publicationType.AllowedFields = splitFields(allowedFields)
+	err = dbp.translate(EntityTypePublicationType, []translatable{publicationType}, opts)
+	return
+}
+func (dbp *DBProvider) PublicationTypeGetByBibtexType(bibtexType string) (publicationType *PublicationType, err error) {
+	publicationType = &PublicationType{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM publication_type WHERE bibtex_type=? ORDER BY id ASC LIMIT 1"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var requiredFields, allowedFields string
+	err = stmt.QueryRow(bibtexType).Scan(&publicationType.Id, &publicationType.Name, &publicationType.CreatedBy, &publicationType.UpdatedBy, &publicationType.CreatedAt, &publicationType.UpdatedAt, (*nullString)(&publicationType.BibtexType), (*nullString)(&requiredFields), (*nullString)(&allowedFields))
 	if err != nil {
 		return
 	}
+	publicationType.RequiredFields = splitFields(requiredFields)
+	publicationType.AllowedFields = splitFields(allowedFields)
 	return
 }
 func (dbp *DBProvider) PublicationTypeCount() (count int64, err error) {
@@ -200,6 +324,9 @@ func (dbp *DBProvider) PublicationTypeGetColumns() []string {
 		"updated_by",
 		"created_at",
 		"updated_at",
+		"bibtex_type",
+		"required_fields",
+		"allowed_fields",
 	}
 	return columns
 }
@@ -210,10 +337,43 @@ func publicationTypeValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 
-func publicationTypeValidate(name string) (verr *ValidationError) {
-	verr = publicationTypeValidateName(name)
-	if verr != nil {
-		return
+func PublicationTypeValidate(name string) (verrs ValidationErrors) {
+	verrs.add(publicationTypeValidateName(name))
+	return
+}
+
+func publicationTypeValidateBibtexType(bibtexType string) (verr *ValidationError) {
+	if verr = validateLength("bibtex_type", bibtexType, 45); verr != nil {
+		return verr
+	}
+	for _, r := range bibtexType {
+		if r < 'a' || r > 'z' {
+			return &ValidationError{"bibtex_type", "can only contain lowercase letters", ValidationCodeInvalidFormat}
+		}
+	}
+	return
+}
+func publicationTypeValidateFields(field string, fields []string) (verr *ValidationError) {
+	for _, f := range fields {
+		if !isPublicationField(f) {
+			return &ValidationError{field, "unknown publication field " + f, ValidationCodeInvalidValue}
+		}
+	}
+	return validateLength(field, strings.Join(fields, ","), 500)
+}
+func PublicationTypeValidateRules(bibtexType string, requiredFields, allowedFields []string) (verrs ValidationErrors) {
+	verrs.add(publicationTypeValidateBibtexType(bibtexType))
+	verrs.add(publicationTypeValidateFields("required_fields", requiredFields))
+	verrs.add(publicationTypeValidateFields("allowed_fields", allowedFields))
+	return
+}
+
+func splitFields(fields string) (list []string) {
+	list = []string{}
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			list = append(list, f)
+		}
 	}
 	return
 }
@@ -15,8 +15,8 @@ type Publisher struct {
 	UpdatedAt int64  `json:"updated_at"`
 }
 
-func (dbp *DBProvider) PublisherCreate(name, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = publisherValidate(name)
+func (dbp *DBProvider) PublisherCreate(name, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = PublisherValidate(name)
 	if verr != nil {
 		return
 	}
@@ -35,7 +35,7 @@ func (dbp *DBProvider) PublisherCreate(name, createdBy string) (id int64, verr *
 	result, err := stmt.Exec(name, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -47,8 +47,8 @@ func (dbp *DBProvider) PublisherCreate(name, createdBy string) (id int64, verr *
 	}
 	return
 }
-func (dbp *DBProvider) PublisherUpdate(id int64, name, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = publisherValidate(name)
+func (dbp *DBProvider) PublisherUpdate(id int64, name, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = PublisherValidate(name)
 	if verr != nil {
 		return
 	}
@@ -67,7 +67,7 @@ func (dbp *DBProvider) PublisherUpdate(id int64, name, updatedBy string) (numRow
 	result, err := stmt.Exec(name, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -210,10 +210,7 @@ func publisherValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 
-func publisherValidate(name string) (verr *ValidationError) {
-	verr = publisherValidateName(name)
-	if verr != nil {
-		return
-	}
+func PublisherValidate(name string) (verrs ValidationErrors) {
+	verrs.add(publisherValidateName(name))
 	return
 }
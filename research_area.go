@@ -1,6 +1,7 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -14,12 +15,13 @@ type ResearchArea struct {
 	UpdatedBy                string `json:"updated_by"`
 	CreatedAt                int64  `json:"created_at"`
 	UpdatedAt                int64  `json:"updated_at"`
+	Parent                   int64  `json:"parent"`
 	RelResearchLineCreatedBy string `json:"research_line_created_by,omitempty"`
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) ResearchAreaCreate(name, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = researchAreaValidate(name)
+func (dbp *DBProvider) ResearchAreaCreate(name, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = ResearchAreaValidate(name)
 	if verr != nil {
 		return
 	}
@@ -38,7 +40,7 @@ func (dbp *DBProvider) ResearchAreaCreate(name, createdBy string) (id int64, ver
 	result, err := stmt.Exec(name, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -50,8 +52,8 @@ func (dbp *DBProvider) ResearchAreaCreate(name, createdBy string) (id int64, ver
 	}
 	return
 }
-func (dbp *DBProvider) ResearchAreaUpdate(id int64, name, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = researchAreaValidate(name)
+func (dbp *DBProvider) ResearchAreaUpdate(id int64, name, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = ResearchAreaValidate(name)
 	if verr != nil {
 		return
 	}
@@ -70,7 +72,7 @@ func (dbp *DBProvider) ResearchAreaUpdate(id int64, name, updatedBy string) (num
 	result, err := stmt.Exec(name, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -82,6 +84,9 @@ func (dbp *DBProvider) ResearchAreaUpdate(id int64, name, updatedBy string) (num
 	}
 	return
 }
+
+// ResearchAreaUpdateLogo sets the logo to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) ResearchAreaUpdateLogo(id int64, logo string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -98,7 +103,7 @@ func (dbp *DBProvider) ResearchAreaUpdateLogo(id int64, logo string, updatedBy s
 	result, err := stmt.Exec(logo, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -108,31 +113,67 @@ func (dbp *DBProvider) ResearchAreaUpdateLogo(id int64, logo string, updatedBy s
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypeResearchArea, id, AssetFieldLogo)
+	}
 	return
 }
+
+// ResearchAreaDelete deletes the research area, its sub-areas are moved to its parent
 func (dbp *DBProvider) ResearchAreaDelete(id int64) (numRows int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
 	}
 	defer db.Close()
-	query := "DELETE FROM research_area WHERE id=?"
-	stmt, err := db.Prepare(query)
+	tx, err := db.Begin()
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
-	result, err := stmt.Exec(id)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var parent nullInt64
+	err = tx.QueryRow("SELECT parent FROM research_area WHERE id=? FOR UPDATE", id).Scan(&parent)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			err = nil
+		}
 		return
 	}
-	numRows, err = result.RowsAffected()
+	_, err = txExec(tx, "UPDATE research_area SET parent=? WHERE parent=?", nullIfZero(int64(parent)), id)
+	if err != nil {
+		return
+	}
+	numRows, err = txExec(tx, "DELETE FROM research_area WHERE id=?", id)
+	if err != nil {
+		return
+	}
+	_, err = txExec(tx, "DELETE FROM translation WHERE entity_type=? AND entity_id=?", EntityTypeResearchArea, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.imageDeleteAll(EntityTypeResearchArea, id)
+		if err != nil {
+			return
+		}
+		err = dbp.assetDeleteAll(EntityTypeResearchArea, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeResearchArea, id)
+	}
 	return
 }
-func (dbp *DBProvider) ResearchAreaGetAll() (researchAreas []*ResearchArea, err error) {
+func (dbp *DBProvider) ResearchAreaGetAll(opts ...QueryOption) (researchAreas []*ResearchArea, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -151,7 +192,7 @@ func (dbp *DBProvider) ResearchAreaGetAll() (researchAreas []*ResearchArea, err
 	defer rows.Close()
 	for rows.Next() {
 		p := ResearchArea{}
-		err = rows.Scan(&p.Id, &p.Name, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		err = rows.Scan(&p.Id, &p.Name, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, (*nullInt64)(&p.Parent))
 		if err != nil {
 			return
 		}
@@ -161,9 +202,14 @@ func (dbp *DBProvider) ResearchAreaGetAll() (researchAreas []*ResearchArea, err
 	if err != nil {
 		return
 	}
+	items := make([]translatable, len(researchAreas))
+	for i, p := range researchAreas {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypeResearchArea, items, opts)
 	return
 }
-func (dbp *DBProvider) ResearchAreaGetById(id int64) (researchArea *ResearchArea, err error) {
+func (dbp *DBProvider) ResearchAreaGetById(id int64, opts ...QueryOption) (researchArea *ResearchArea, err error) {
 	researchArea = &ResearchArea{}
 	db, err := dbp.getDB()
 	if err != nil {
@@ -176,10 +222,11 @@ func (dbp *DBProvider) ResearchAreaGetById(id int64) (researchArea *ResearchArea
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&researchArea.Id, &researchArea.Name, &researchArea.Logo, &researchArea.CreatedBy, &researchArea.UpdatedBy, &researchArea.CreatedAt, &researchArea.UpdatedAt)
+	err = stmt.QueryRow(id).Scan(&researchArea.Id, &researchArea.Name, &researchArea.Logo, &researchArea.CreatedBy, &researchArea.UpdatedBy, &researchArea.CreatedAt, &researchArea.UpdatedAt, (*nullInt64)(&researchArea.Parent))
 	if err != nil {
 		return
 	}
+	err = dbp.translate(EntityTypeResearchArea, []translatable{researchArea}, opts)
 	return
 }
 func (dbp *DBProvider) ResearchAreaGetByResearchLine(researchLineId int64) (researchAreas []*ResearchArea, err error) {
@@ -201,7 +248,7 @@ func (dbp *DBProvider) ResearchAreaGetByResearchLine(researchLineId int64) (rese
 	defer rows.Close()
 	for rows.Next() {
 		p := ResearchArea{}
-		err = rows.Scan(&p.Id, &p.Name, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
+		err = rows.Scan(&p.Id, &p.Name, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, (*nullInt64)(&p.Parent), &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
 		if err != nil {
 			return
 		}
@@ -260,7 +307,7 @@ func (dbp *DBProvider) ResearchAreaAddResearchLine(id, researchLineId int64, cre
 		return
 	}
 	if researchLine.PrimaryResearchArea == id {
-		verr = &ValidationError{"research_line", "this research line has this research area as primary"}
+		verr = &ValidationError{"research_line", "this research line has this research area as primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -278,12 +325,12 @@ func (dbp *DBProvider) ResearchAreaAddResearchLine(id, researchLineId int64, cre
 	_, err = stmt.Exec(id, researchLineId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research line has already been added"}
+			verr = &ValidationError{"research_line", "this research line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -330,6 +377,7 @@ func (dbp *DBProvider) ResearchAreaGetColumns() []string {
 		"updated_by",
 		"created_at",
 		"updated_at",
+		"parent",
 	}
 	return columns
 }
@@ -339,10 +387,7 @@ func researchAreaValidateName(name string) (verr *ValidationError) {
 	}
 	return validateLength("name", name, 200)
 }
-func researchAreaValidate(name string) (verr *ValidationError) {
-	verr = researchAreaValidateName(name)
-	if verr != nil {
-		return
-	}
+func ResearchAreaValidate(name string) (verrs ValidationErrors) {
+	verrs.add(researchAreaValidateName(name))
 	return
 }
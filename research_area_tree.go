@@ -0,0 +1,176 @@
+package instantolib
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// ResearchAreaUpdateParent moves the research area under parentId, or to the top level if parentId is 0.
+// An area cannot be moved under itself or under any of its sub-areas.
+func (dbp *DBProvider) ResearchAreaUpdateParent(id, parentId int64, updatedBy string) (numRows int64, verr *ValidationError, err error) {
+	if parentId != 0 {
+		if parentId == id {
+			verr = &ValidationError{"parent", "a research area cannot be its own parent", ValidationCodeConflict}
+			return
+		}
+		var ancestors []*ResearchArea
+		ancestors, err = dbp.ResearchAreaGetAncestors(parentId)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				verr = &ValidationError{"parent", "not exist", ValidationCodeNotFound}
+				err = nil
+			}
+			return
+		}
+		for _, ancestor := range ancestors {
+			if ancestor.Id == id {
+				verr = &ValidationError{"parent", "a research area cannot be moved under one of its sub-areas", ValidationCodeConflict}
+				return
+			}
+		}
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE research_area SET parent=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(nullIfZero(parentId), updatedBy, ts, id)
+	if err != nil {
+		if is, field := IsDbError1452(err); is {
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
+			err = nil
+			return
+		}
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ResearchAreaGetRoots returns the research areas at the top level
+func (dbp *DBProvider) ResearchAreaGetRoots(opts ...QueryOption) (researchAreas []*ResearchArea, err error) {
+	researchAreas, err = dbp.researchAreaGetByParent(opts, "SELECT * FROM research_area WHERE parent IS NULL")
+	return
+}
+
+// ResearchAreaGetChildren returns the direct sub-areas of the research area
+func (dbp *DBProvider) ResearchAreaGetChildren(id int64, opts ...QueryOption) (researchAreas []*ResearchArea, err error) {
+	researchAreas, err = dbp.researchAreaGetByParent(opts, "SELECT * FROM research_area WHERE parent=?", id)
+	return
+}
+func (dbp *DBProvider) researchAreaGetByParent(opts []QueryOption, query string, args ...interface{}) (researchAreas []*ResearchArea, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ResearchArea{}
+		err = rows.Scan(&p.Id, &p.Name, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, (*nullInt64)(&p.Parent))
+		if err != nil {
+			return
+		}
+		researchAreas = append(researchAreas, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	items := make([]translatable, len(researchAreas))
+	for i, p := range researchAreas {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypeResearchArea, items, opts)
+	return
+}
+
+// ResearchAreaGetAncestors returns the areas above the research area, top level first.
+// It returns sql.ErrNoRows if the research area does not exist.
+func (dbp *DBProvider) ResearchAreaGetAncestors(id int64, opts ...QueryOption) (ancestors []*ResearchArea, err error) {
+	areas, _, err := dbp.researchAreaTree(opts)
+	if err != nil {
+		return
+	}
+	area, ok := areas[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+	// visited guards against cycles left in the table by hand
+	visited := map[int64]bool{id: true}
+	for area.Parent != 0 && !visited[area.Parent] {
+		parent, ok := areas[area.Parent]
+		if !ok {
+			break
+		}
+		visited[parent.Id] = true
+		ancestors = append([]*ResearchArea{parent}, ancestors...)
+		area = parent
+	}
+	return
+}
+
+// ResearchAreaGetSubtree returns the research area followed by all its sub-areas, depth first.
+// It returns sql.ErrNoRows if the research area does not exist.
+func (dbp *DBProvider) ResearchAreaGetSubtree(id int64, opts ...QueryOption) (subtree []*ResearchArea, err error) {
+	areas, children, err := dbp.researchAreaTree(opts)
+	if err != nil {
+		return
+	}
+	area, ok := areas[id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+	visited := make(map[int64]bool)
+	var walk func(area *ResearchArea)
+	walk = func(area *ResearchArea) {
+		if visited[area.Id] {
+			return
+		}
+		visited[area.Id] = true
+		subtree = append(subtree, area)
+		for _, child := range children[area.Id] {
+			walk(child)
+		}
+	}
+	walk(area)
+	return
+}
+
+// researchAreaTree loads every research area indexed by id and by parent
+func (dbp *DBProvider) researchAreaTree(opts []QueryOption) (areas map[int64]*ResearchArea, children map[int64][]*ResearchArea, err error) {
+	all, err := dbp.ResearchAreaGetAll(opts...)
+	if err != nil {
+		return
+	}
+	areas = make(map[int64]*ResearchArea)
+	children = make(map[int64][]*ResearchArea)
+	for _, area := range all {
+		areas[area.Id] = area
+		children[area.Parent] = append(children[area.Parent], area)
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -35,8 +36,8 @@ type ResearchLine struct {
 	RelResourceCreatedAt        int64  `json:"resource_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) ResearchLineCreate(title string, finished bool, description, createdBy string, primaryResearchArea int64) (id int64, verr *ValidationError, err error) {
-	verr = researchLineValidate(title, description)
+func (dbp *DBProvider) ResearchLineCreate(title string, finished bool, description, createdBy string, primaryResearchArea int64) (id int64, verr ValidationErrors, err error) {
+	verr = ResearchLineValidate(title, description)
 	if verr != nil {
 		return
 	}
@@ -55,7 +56,7 @@ func (dbp *DBProvider) ResearchLineCreate(title string, finished bool, descripti
 	result, err := stmt.Exec(title, finished, description, createdBy, createdBy, ts, ts, primaryResearchArea)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -67,8 +68,8 @@ func (dbp *DBProvider) ResearchLineCreate(title string, finished bool, descripti
 	}
 	return
 }
-func (dbp *DBProvider) ResearchLineUpdate(id int64, title string, finished bool, description string, updatedBy string, primaryResearchArea int64) (numRows int64, verr *ValidationError, err error) {
-	verr = researchLineValidate(title, description)
+func (dbp *DBProvider) ResearchLineUpdate(id int64, title string, finished bool, description string, updatedBy string, primaryResearchArea int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = ResearchLineValidate(title, description)
 	if verr != nil {
 		return
 	}
@@ -87,7 +88,7 @@ func (dbp *DBProvider) ResearchLineUpdate(id int64, title string, finished bool,
 	result, err := stmt.Exec(title, finished, description, updatedBy, ts, primaryResearchArea, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -99,6 +100,9 @@ func (dbp *DBProvider) ResearchLineUpdate(id int64, title string, finished bool,
 	}
 	return
 }
+
+// ResearchLineUpdateLogo sets the logo to a URL of a file not managed by the library, releasing the managed one if any.
+// Use AssetSet or the upload functions for managed files.
 func (dbp *DBProvider) ResearchLineUpdateLogo(id int64, logo string, updatedBy string) (numRows int64, verr *ValidationError, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -115,7 +119,7 @@ func (dbp *DBProvider) ResearchLineUpdateLogo(id int64, logo string, updatedBy s
 	result, err := stmt.Exec(logo, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = &ValidationError{field, "not exists", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -125,6 +129,9 @@ func (dbp *DBProvider) ResearchLineUpdateLogo(id int64, logo string, updatedBy s
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.assetDetach(EntityTypeResearchLine, id, AssetFieldLogo)
+	}
 	return
 }
 func (dbp *DBProvider) ResearchLineDelete(id int64) (numRows int64, err error) {
@@ -147,9 +154,54 @@ func (dbp *DBProvider) ResearchLineDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.categoryUntagAll(EntityTypeResearchLine, id)
+		if err != nil {
+			return
+		}
+		err = dbp.translationDeleteAll(EntityTypeResearchLine, id)
+		if err != nil {
+			return
+		}
+		err = dbp.imageDeleteAll(EntityTypeResearchLine, id)
+		if err != nil {
+			return
+		}
+		err = dbp.assetDeleteAll(EntityTypeResearchLine, id)
+		if err != nil {
+			return
+		}
+		err = dbp.attachmentDeleteAll(EntityTypeResearchLine, id)
+	}
+	return
+}
+func (dbp *DBProvider) ResearchLineDeletePreview(id int64) (preview *DeletePreview, err error) {
+	exists, err := dbp.ResearchLineExists(id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = sql.ErrNoRows
+		return
+	}
+	specs := []deletePreviewSpec{
+		{"research_areas", "research_area_research_line", DeletePreviewDetached, "research_area", "research_line"},
+		{"members", "research_line_member", DeletePreviewDetached, "member", "research_line"},
+		{"publications", "research_line_publication", DeletePreviewDetached, "publication", "research_line"},
+		{"articles", "research_line_article", DeletePreviewDetached, "article", "research_line"},
+		{"resources", "research_line_resource", DeletePreviewDetached, "resource", "research_line"},
+		{"student_works", "research_line_student_work", DeletePreviewDetached, "student_work", "research_line"},
+		{"financed_projects", "research_line_financed_project", DeletePreviewDetached, "financed_project", "research_line"},
+		{"partners", "research_line_partner", DeletePreviewDetached, "partner", "research_line"},
+	}
+	preview, err = dbp.deletePreview(id, specs)
+	if err != nil {
+		return
+	}
+	err = dbp.deletePreviewAttachments(preview, EntityTypeResearchLine, id)
 	return
 }
-func (dbp *DBProvider) ResearchLineGetAll() (researchLines []*ResearchLine, err error) {
+func (dbp *DBProvider) ResearchLineGetAll(opts ...QueryOption) (researchLines []*ResearchLine, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -178,9 +230,14 @@ func (dbp *DBProvider) ResearchLineGetAll() (researchLines []*ResearchLine, err
 	if err != nil {
 		return
 	}
+	items := make([]translatable, len(researchLines))
+	for i, p := range researchLines {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypeResearchLine, items, opts)
 	return
 }
-func (dbp *DBProvider) ResearchLineGetById(id int64) (researchLine *ResearchLine, err error) {
+func (dbp *DBProvider) ResearchLineGetById(id int64, opts ...QueryOption) (researchLine *ResearchLine, err error) {
 	researchLine = &ResearchLine{}
 	db, err := dbp.getDB()
 	if err != nil {
@@ -197,6 +254,7 @@ func (dbp *DBProvider) ResearchLineGetById(id int64) (researchLine *ResearchLine
 	if err != nil {
 		return
 	}
+	err = dbp.translate(EntityTypeResearchLine, []translatable{researchLine}, opts)
 	return
 }
 func (dbp *DBProvider) ResearchLineGetByPrimaryResearchArea(researchAreaId int64) (researchLines []*ResearchLine, err error) {
@@ -262,6 +320,56 @@ func (dbp *DBProvider) ResearchLineGetByResearchArea(researchAreaId int64) (rese
 	}
 	return
 }
+
+// ResearchLineGetByResearchAreaSubtree returns the research lines added to the research area or to any of its sub-areas.
+// A line added to several of those areas is listed once.
+func (dbp *DBProvider) ResearchLineGetByResearchAreaSubtree(researchAreaId int64) (researchLines []*ResearchLine, err error) {
+	subtree, err := dbp.ResearchAreaGetSubtree(researchAreaId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		return
+	}
+	args := make([]interface{}, len(subtree))
+	for i, area := range subtree {
+		args[i] = area.Id
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT research_line.*,research_area_research_line.created_by,research_area_research_line.created_at FROM research_area_research_line INNER JOIN research_line ON research_area_research_line.research_line=research_line.id WHERE research_area IN (" + sqlPlaceholders(len(args)) + ") ORDER BY research_area_research_line.created_at ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	seen := make(map[int64]bool)
+	for rows.Next() {
+		p := ResearchLine{}
+		err = rows.Scan(&p.Id, &p.Title, &p.Finished, &p.Description, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryResearchArea, &p.RelResearchAreaCreatedBy, &p.RelResearchAreaCreatedAt)
+		if err != nil {
+			return
+		}
+		if seen[p.Id] {
+			continue
+		}
+		seen[p.Id] = true
+		researchLines = append(researchLines, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) ResearchLineGetByFinancedProject(financedProjectId int64) (researchLines []*ResearchLine, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -455,13 +563,13 @@ func (dbp *DBProvider) ResearchLineGetByResource(resourceId int64) (researchLine
 		return
 	}
 	defer db.Close()
-	query := "SELECT research_line.*,research_line_resource.created_by,research_line_resource.created_at FROM research_line_resource INNER JOIN research_line ON research_line_resource.research_line=research_line.id  WHERE resource=?"
+	query := "SELECT research_line.*,attachment.created_by,attachment.created_at FROM attachment INNER JOIN research_line ON attachment.entity_id=research_line.id WHERE attachment.entity_type=? AND attachment.resource=?"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(resourceId)
+	rows, err := stmt.Query(EntityTypeResearchLine, resourceId)
 	if err != nil {
 		return
 	}
@@ -481,6 +589,37 @@ func (dbp *DBProvider) ResearchLineGetByResource(resourceId int64) (researchLine
 	return
 }
 
+func (dbp *DBProvider) ResearchLineGetByCategory(categoryId int64) (researchLines []*ResearchLine, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT research_line.* FROM category_tag INNER JOIN research_line ON category_tag.entity_id=research_line.id WHERE category_tag.category=? AND category_tag.entity_type=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(categoryId, EntityTypeResearchLine)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ResearchLine{}
+		err = rows.Scan(&p.Id, &p.Title, &p.Finished, &p.Description, &p.Logo, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.PrimaryResearchArea)
+		if err != nil {
+			return
+		}
+		researchLines = append(researchLines, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) ResearchLineCount() (count int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
@@ -529,7 +668,7 @@ func (dbp *DBProvider) ResearchLineAddResearchArea(id, researchAreaId int64, cre
 		return
 	}
 	if researchLine.PrimaryResearchArea == researchAreaId {
-		verr = &ValidationError{"research_area", "this research area is already the primary"}
+		verr = &ValidationError{"research_area", "this research area is already the primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -547,12 +686,12 @@ func (dbp *DBProvider) ResearchLineAddResearchArea(id, researchAreaId int64, cre
 	_, err = stmt.Exec(researchAreaId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_area", "this research area has already been added"}
+			verr = &ValidationError{"research_area", "this research area has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -607,12 +746,12 @@ func (dbp *DBProvider) ResearchLineAddFinancedProject(id, financedProjectId int6
 	_, err = stmt.Exec(id, financedProjectId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"financed_project", "this financed project has already been added"}
+			verr = &ValidationError{"financed_project", "this financed project has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -666,12 +805,12 @@ func (dbp *DBProvider) ResearchLineAddArticle(id, articleId int64, createdBy str
 	_, err = stmt.Exec(id, articleId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"article", "this article has already been added"}
+			verr = &ValidationError{"article", "this article has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -725,12 +864,12 @@ func (dbp *DBProvider) ResearchLineAddPartner(id, partnerId int64, createdBy str
 	_, err = stmt.Exec(id, partnerId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"partner", "this partner has already been added"}
+			verr = &ValidationError{"partner", "this partner has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -785,12 +924,12 @@ func (dbp *DBProvider) ResearchLineAddMember(id, memberId int64, createdBy strin
 	_, err = stmt.Exec(id, memberId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this member has already been added"}
+			verr = &ValidationError{"member", "this member has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -845,12 +984,12 @@ func (dbp *DBProvider) ResearchLineAddPublication(id, publicationId int64, creat
 	_, err = stmt.Exec(id, publicationId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"publication", "this publication has already been added"}
+			verr = &ValidationError{"publication", "this publication has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -904,12 +1043,12 @@ func (dbp *DBProvider) ResearchLineAddStudentWork(id, studentWorkId int64, creat
 	_, err = stmt.Exec(id, studentWorkId, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"student_work", "this student work has already been added"}
+			verr = &ValidationError{"student_work", "this student work has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -971,14 +1110,8 @@ func researchLineValidateTitle(title string) (verr *ValidationError) {
 func researchLineValidateDescription(description string) (verr *ValidationError) {
 	return validateLength("description", description, 65000)
 }
-func researchLineValidate(title, description string) (verr *ValidationError) {
-	verr = researchLineValidateTitle(title)
-	if verr != nil {
-		return
-	}
-	verr = researchLineValidateDescription(description)
-	if verr != nil {
-		return
-	}
+func ResearchLineValidate(title, description string) (verrs ValidationErrors) {
+	verrs.add(researchLineValidateTitle(title))
+	verrs.add(researchLineValidateDescription(description))
 	return
 }
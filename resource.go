@@ -1,13 +1,14 @@
 package instantolib
 
 import (
+	"database/sql"
 	"time"
 )
 
 type Resource struct {
 	Id                       int64  `json:"id"`
 	Filename                 string `json:"filename"`
-	MimeType                 int64  `json:"mime_type"`
+	MimeType                 string `json:"mime_type"`
 	Size                     int64  `json:"size"`
 	Private                  bool   `json:"private"`
 	ResourceType             int64  `json:"resource_type"`
@@ -15,21 +16,33 @@ type Resource struct {
 	UpdatedBy                string `json:"updated_by"`
 	CreatedAt                int64  `json:"created_at"`
 	UpdatedAt                int64  `json:"updated_at"`
+	BlobKey                  string `json:"-"`
+	Checksum                 string `json:"checksum"`
 	RelResearchLineCreatedBy string `json:"research_line_created_by,omitempty"`
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
+	RelAttachmentId          int64  `json:"attachment_id,omitempty"`
+	RelAttachmentLabel       string `json:"attachment_label,omitempty"`
+	RelAttachmentPosition    int64  `json:"attachment_position,omitempty"`
+	RelAttachmentCreatedBy   string `json:"attachment_created_by,omitempty"`
+	RelAttachmentCreatedAt   int64  `json:"attachment_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) ResourceCreate(filename, mimeType string, size int64, private bool, createdBy string, resourceType int64) (id int64, verr *ValidationError, err error) {
-	verr = resourceValidate(filename, mimeType, size)
+// ResourceCreate creates a resource, which must be of a MIME type and size accepted by its resource type
+func (dbp *DBProvider) ResourceCreate(filename, mimeType string, size int64, private bool, createdBy string, resourceType int64) (id int64, verr ValidationErrors, err error) {
+	verr = ResourceValidate(filename, mimeType, size)
 	if verr != nil {
 		return
 	}
+	verr, err = dbp.resourceCheckType(resourceType, mimeType, size)
+	if err != nil || verr != nil {
+		return
+	}
 	db, err := dbp.getDB()
 	if err != nil {
 		return
 	}
 	defer db.Close()
-	query := "INSERT INTO resource(filename,mime_type,size,private,created_by,updated_by,created_at,updated_at,resource_type) VALUES(?,?,?,?,?,?,?,?)"
+	query := "INSERT INTO resource(filename,mime_type,size,private,created_by,updated_by,created_at,updated_at,resource_type) VALUES(?,?,?,?,?,?,?,?,?)"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
@@ -39,7 +52,7 @@ func (dbp *DBProvider) ResourceCreate(filename, mimeType string, size int64, pri
 	result, err := stmt.Exec(filename, mimeType, size, private, createdBy, createdBy, ts, ts, resourceType)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -51,58 +64,116 @@ func (dbp *DBProvider) ResourceCreate(filename, mimeType string, size int64, pri
 	}
 	return
 }
-func (dbp *DBProvider) ResourceUpdate(id int64, filename, mimeType string, size int64, private bool, updatedBy string, resourceType int64) (numRows int64, verr *ValidationError, err error) {
-	verr = resourceValidate(filename, mimeType, size)
+
+// ResourceUpdate updates the resource. A new filename or MIME type replaces the current version, which is kept
+// as a previous version. The size of uploaded contents cannot change, ResourceUploadVersion replaces them.
+func (dbp *DBProvider) ResourceUpdate(id int64, filename, mimeType string, size int64, private bool, updatedBy string, resourceType int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = ResourceValidate(filename, mimeType, size)
 	if verr != nil {
 		return
 	}
+	verr, err = dbp.resourceCheckType(resourceType, mimeType, size)
+	if err != nil || verr != nil {
+		return
+	}
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		return
+	}
+	if resource.BlobKey != "" && size != resource.Size {
+		verr = ValidationErrors{{"size", "cannot change for uploaded contents, upload a new version", ValidationCodeConflict}}
+		return
+	}
 	db, err := dbp.getDB()
 	if err != nil {
 		return
 	}
 	defer db.Close()
-	query := "UPDATE resource SET filename=?,mime_type=?,size=?,private=?,updated_by=?,updated_at=?,resource_type=? WHERE id=?"
-	stmt, err := db.Prepare(query)
+	tx, err := db.Begin()
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 	ts := time.Now().Unix()
-	result, err := stmt.Exec(filename, mimeType, size, private, updatedBy, ts, resourceType, id)
+	if filename != resource.Filename || mimeType != resource.MimeType || size != resource.Size {
+		current := &ResourceVersion{Filename: filename, MimeType: mimeType, Size: size, BlobKey: resource.BlobKey, Checksum: resource.Checksum}
+		_, err = txResourceReplaceVersion(tx, id, current, updatedBy, ts)
+		if err != nil {
+			return
+		}
+	}
+	numRows, err = txExec(tx, "UPDATE resource SET private=?,updated_by=?,updated_at=?,resource_type=? WHERE id=?", private, updatedBy, ts, resourceType, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			tx.Rollback()
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
 		return
 	}
-	numRows, err = result.RowsAffected()
-	if err != nil {
-		return
-	}
+	err = tx.Commit()
 	return
 }
+
+// ResourceDelete deletes the resource with its previous versions, and their stored contents once nothing else uses them
 func (dbp *DBProvider) ResourceDelete(id int64) (numRows int64, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
 	}
 	defer db.Close()
-	query := "DELETE FROM resource WHERE id=?"
-	stmt, err := db.Prepare(query)
+	tx, err := db.Begin()
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
-	result, err := stmt.Exec(id)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var blobKey string
+	err = tx.QueryRow("SELECT blob_key FROM resource WHERE id=? FOR UPDATE", id).Scan((*nullString)(&blobKey))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			err = tx.Rollback()
+		}
 		return
 	}
-	numRows, err = result.RowsAffected()
+	keys, err := txResourceVersionDeleteAll(tx, id)
 	if err != nil {
 		return
 	}
+	numRows, err = txExec(tx, "DELETE FROM resource WHERE id=?", id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	err = dbp.categoryUntagAll(EntityTypeResource, id)
+	if err != nil {
+		return
+	}
+	err = dbp.assetUnlinkResource(id)
+	if err != nil {
+		return
+	}
+	// the blobs are only released once the rows referencing them are gone for good
+	for _, key := range append(keys, blobKey) {
+		err = dbp.deleteUnusedBlob(key)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 func (dbp *DBProvider) ResourceGetAll() (resources []*Resource, err error) {
@@ -124,7 +195,7 @@ func (dbp *DBProvider) ResourceGetAll() (resources []*Resource, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		p := Resource{}
-		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType)
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum))
 		if err != nil {
 			return
 		}
@@ -149,7 +220,7 @@ func (dbp *DBProvider) ResourceGetById(id int64) (resource *Resource, err error)
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&resource.Id, &resource.Filename, &resource.MimeType, &resource.Size, &resource.Private, &resource.CreatedBy, &resource.UpdatedBy, &resource.CreatedAt, &resource.UpdatedAt, &resource.ResourceType)
+	err = stmt.QueryRow(id).Scan(&resource.Id, &resource.Filename, &resource.MimeType, &resource.Size, &resource.Private, &resource.CreatedBy, &resource.UpdatedBy, &resource.CreatedAt, &resource.UpdatedAt, &resource.ResourceType, (*nullString)(&resource.BlobKey), (*nullString)(&resource.Checksum))
 	if err != nil {
 		return
 	}
@@ -174,7 +245,7 @@ func (dbp *DBProvider) ResourceGetByResourceType(resourceTypeId int64) (resource
 	defer rows.Close()
 	for rows.Next() {
 		p := Resource{}
-		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType)
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum))
 		if err != nil {
 			return
 		}
@@ -192,20 +263,51 @@ func (dbp *DBProvider) ResourceGetByResearchLine(researchLineId int64) (resource
 		return
 	}
 	defer db.Close()
-	query := "SELECT resource.*,research_line_resource.created_by,research_line_resource.created_at FROM research_line_resource INNER JOIN resource ON research_line_resource.resource=resource.id  WHERE research_line=? ORDER BY filename ASC"
+	query := "SELECT resource.*,attachment.created_by,attachment.created_at FROM attachment INNER JOIN resource ON attachment.resource=resource.id WHERE attachment.entity_type=? AND attachment.entity_id=? ORDER BY filename ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(EntityTypeResearchLine, researchLineId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Resource{}
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum), &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
+		if err != nil {
+			return
+		}
+		resources = append(resources, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ResourceGetByCategory(categoryId int64) (resources []*Resource, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT resource.* FROM category_tag INNER JOIN resource ON category_tag.entity_id=resource.id WHERE category_tag.category=? AND category_tag.entity_type=?"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(researchLineId)
+	rows, err := stmt.Query(categoryId, EntityTypeResource)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		p := Resource{}
-		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, &p.RelResearchLineCreatedBy, &p.RelResearchLineCreatedAt)
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum))
 		if err != nil {
 			return
 		}
@@ -258,32 +360,16 @@ func (dbp *DBProvider) ResourceExists(id int64) (exists bool, err error) {
 	exists = true
 	return
 }
+
+// ResourceAddResearchLine attaches the resource to a research line without a label, see AttachmentAdd
 func (dbp *DBProvider) ResourceAddResearchLine(id, researchLineId int64, createdBy string) (verr *ValidationError, err error) {
-	db, err := dbp.getDB()
-	if err != nil {
+	_, verrs, err := dbp.AttachmentAdd(EntityTypeResearchLine, researchLineId, id, "", createdBy)
+	if err != nil || verrs == nil {
 		return
 	}
-	defer db.Close()
-	query := "INSERT INTO research_line_resource(research_line,resource,created_by,created_at) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return
-	}
-	defer stmt.Close()
-	ts := time.Now().Unix()
-	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
-	if err != nil {
-		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research_line has already been added"}
-			err = nil
-			return
-		}
-		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
-			err = nil
-			return
-		}
-		return
+	verr = verrs[0]
+	if verr.Code == ValidationCodeDuplicate {
+		verr = &ValidationError{"research_line", "this research_line has already been added", ValidationCodeDuplicate}
 	}
 	return
 }
@@ -293,13 +379,13 @@ func (dbp *DBProvider) ResourceRemoveResearchLine(id, researchLineId int64) (rem
 		return
 	}
 	defer db.Close()
-	query := "DELETE FROM research_line_resource WHERE research_line=? AND resource=?"
+	query := "DELETE FROM attachment WHERE entity_type=? AND entity_id=? AND resource=?"
 	stmt, err := db.Prepare(query)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	result, err := stmt.Exec(researchLineId, id)
+	result, err := stmt.Exec(EntityTypeResearchLine, researchLineId, id)
 	if err != nil {
 		return
 	}
@@ -322,15 +408,16 @@ func (dbp *DBProvider) ResourceGetColumns() []string {
 	columns := []string{
 		"id",
 		"filename",
-		"mimeType",
+		"mime_type",
 		"size",
 		"private",
-		"resource_type",
 		"created_by",
 		"updated_by",
 		"created_at",
 		"updated_at",
-		"resourceType",
+		"resource_type",
+		"blob_key",
+		"checksum",
 	}
 	return columns
 }
@@ -341,23 +428,14 @@ func resourceValidateFilename(filename string) (verr *ValidationError) {
 	return validateLength("filename", filename, 200)
 }
 func resourceValidateMimeType(mimeType string) (verr *ValidationError) {
-	return validateLength("mimeType", mimeType, 200)
+	return validateLength("mime_type", mimeType, 200)
 }
 func resourceValidateDate(size int64) (verr *ValidationError) {
 	return validateIsNumber("size", size)
 }
-func resourceValidate(filename, mimeType string, size int64) (verr *ValidationError) {
-	verr = resourceValidateFilename(filename)
-	if verr != nil {
-		return
-	}
-	verr = resourceValidateMimeType(mimeType)
-	if verr != nil {
-		return
-	}
-	verr = resourceValidateDate(size)
-	if verr != nil {
-		return
-	}
+func ResourceValidate(filename, mimeType string, size int64) (verrs ValidationErrors) {
+	verrs.add(resourceValidateFilename(filename))
+	verrs.add(resourceValidateMimeType(mimeType))
+	verrs.add(resourceValidateDate(size))
 	return
 }
@@ -0,0 +1,224 @@
+package instantolib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// PermissionResourceReadPrivate is the permission needed to obtain and use links to private resources
+const PermissionResourceReadPrivate = "resource_read_private"
+
+// MaxResourceLinkTTL is the longest a signed link to a private resource can be valid for
+const MaxResourceLinkTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrNoSigningKey is returned when links are signed or checked before calling SetSigningKey
+	ErrNoSigningKey = errors.New("no signing key configured")
+	// ErrInvalidToken is returned for a link token that is malformed, tampered with or for another resource
+	ErrInvalidToken = errors.New("invalid resource token")
+	// ErrTokenExpired is returned for a link token used after its expiry
+	ErrTokenExpired = errors.New("resource token expired")
+	// ErrAccessDenied is returned when the user is not allowed to read the resource
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// ResourceDownload is a logged download of a private resource
+type ResourceDownload struct {
+	Id         int64  `json:"id"`
+	Resource   int64  `json:"resource"`
+	Username   string `json:"username"`
+	RemoteAddr string `json:"remote_addr"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// ResourceSignLink returns a token that lets username download the resource until expires.
+// Public resources need no token, so an empty token is returned for them.
+func (dbp *DBProvider) ResourceSignLink(id int64, username string, ttl time.Duration) (token string, expires int64, verr ValidationErrors, err error) {
+	if len(dbp.signingKey) == 0 {
+		err = ErrNoSigningKey
+		return
+	}
+	if ttl <= 0 || ttl > MaxResourceLinkTTL {
+		verr = ValidationErrors{{"ttl", fmt.Sprintf("must be greater than 0 and at most %s", MaxResourceLinkTTL), ValidationCodeOutOfRange}}
+		return
+	}
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		return
+	}
+	if !resource.Private {
+		return
+	}
+	allowed, err := dbp.UserHasPermission(username, PermissionResourceReadPrivate)
+	if err != nil {
+		return
+	}
+	if !allowed {
+		err = ErrAccessDenied
+		return
+	}
+	expires = time.Now().Add(ttl).Unix()
+	payload := fmt.Sprintf("%d:%d:%s", id, expires, username)
+	token = base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(dbp.sign(payload))
+	return
+}
+
+// ResourceCheckAccess checks username can read the resource with token.
+// Public resources can be read by anyone. Private resources need a valid token issued to username,
+// who must still hold PermissionResourceReadPrivate, so revoking the permission revokes its links.
+func (dbp *DBProvider) ResourceCheckAccess(id int64, token, username string) (resource *Resource, err error) {
+	resource, err = dbp.ResourceGetById(id)
+	if err != nil {
+		return
+	}
+	if !resource.Private {
+		return
+	}
+	tokenId, expires, tokenUsername, err := dbp.resourceParseToken(token)
+	if err != nil {
+		return
+	}
+	if tokenId != id || tokenUsername != username {
+		err = ErrInvalidToken
+		return
+	}
+	if time.Now().Unix() > expires {
+		err = ErrTokenExpired
+		return
+	}
+	allowed, err := dbp.UserHasPermission(username, PermissionResourceReadPrivate)
+	if err != nil {
+		return
+	}
+	if !allowed {
+		err = ErrAccessDenied
+	}
+	return
+}
+
+// ResourceDownload checks access like ResourceCheckAccess and returns the contents of the resource.
+// Downloads of private resources are logged with username and remoteAddr.
+func (dbp *DBProvider) ResourceDownload(id int64, token, username, remoteAddr string) (rc io.ReadCloser, resource *Resource, err error) {
+	resource, err = dbp.ResourceCheckAccess(id, token, username)
+	if err != nil {
+		return
+	}
+	rc, err = dbp.resourceOpen(id)
+	if err != nil {
+		return
+	}
+	if resource.Private {
+		err = dbp.resourceLogDownload(id, username, remoteAddr)
+		if err != nil {
+			rc.Close()
+			rc = nil
+			return
+		}
+	}
+	return
+}
+
+// ResourceGetDownloads returns the logged downloads of a resource, most recent first.
+// The log is kept when the resource is deleted.
+func (dbp *DBProvider) ResourceGetDownloads(id int64) (downloads []*ResourceDownload, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM resource_download WHERE resource=? ORDER BY created_at DESC,id DESC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ResourceDownload{}
+		err = rows.Scan(&p.Id, &p.Resource, &p.Username, &p.RemoteAddr, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		downloads = append(downloads, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) resourceLogDownload(id int64, username, remoteAddr string) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO resource_download(resource,username,remote_addr,created_at) VALUES(?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id, username, remoteAddr, time.Now().Unix())
+	if err != nil {
+		return
+	}
+	return
+}
+
+// resourceParseToken checks the signature of token and returns what it was issued for
+func (dbp *DBProvider) resourceParseToken(token string) (id, expires int64, username string, err error) {
+	if len(dbp.signingKey) == 0 {
+		err = ErrNoSigningKey
+		return
+	}
+	err = ErrInvalidToken
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return
+	}
+	payload, decodeErr := base64.RawURLEncoding.DecodeString(parts[0])
+	if decodeErr != nil {
+		return
+	}
+	signature, decodeErr := base64.RawURLEncoding.DecodeString(parts[1])
+	if decodeErr != nil || !hmac.Equal(signature, dbp.sign(string(payload))) {
+		return
+	}
+	fields := strings.SplitN(string(payload), ":", 3)
+	if len(fields) != 3 {
+		return
+	}
+	id, parseErr := strconv.ParseInt(fields[0], 10, 64)
+	if parseErr != nil {
+		return
+	}
+	expires, parseErr = strconv.ParseInt(fields[1], 10, 64)
+	if parseErr != nil {
+		return
+	}
+	username, err = fields[2], nil
+	return
+}
+func (dbp *DBProvider) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, dbp.signingKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (dbp *DBProvider) ResourceDownloadGetColumns() []string {
+	return []string{"id", "resource", "username", "remote_addr", "created_at"}
+}
@@ -0,0 +1,386 @@
+package instantolib
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const (
+	ResourceVerifyOk         = "ok"
+	ResourceVerifyMissing    = "missing"
+	ResourceVerifyCorrupted  = "corrupted"
+	ResourceVerifyNoChecksum = "no_checksum"
+)
+
+// ResourceMeta is the metadata of an uploaded resource. MimeType is detected from the contents,
+// or from the extension of Filename when the contents do not tell, if left empty.
+type ResourceMeta struct {
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mime_type"`
+	Private      bool   `json:"private"`
+	ResourceType int64  `json:"resource_type"`
+}
+
+// ResourceVerification is the result of checking the stored contents of a resource against its checksum
+type ResourceVerification struct {
+	Resource int64  `json:"resource"`
+	Status   string `json:"status"`
+	Checksum string `json:"checksum,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+// ResourceUpload stores the contents read from r in the blob store and creates the resource describing them.
+// The contents must be of a MIME type and size accepted by the resource type.
+// Contents are stored under their SHA-256 checksum, so identical contents are stored once and shared
+// by every resource with that checksum. If the resource cannot be created new contents are removed.
+func (dbp *DBProvider) ResourceUpload(r io.Reader, meta *ResourceMeta, createdBy string) (id int64, verr ValidationErrors, err error) {
+	contents, verr, err := dbp.resourceStore(r, meta.Filename, meta.MimeType, meta.ResourceType)
+	if err != nil || verr != nil {
+		return
+	}
+	defer func() {
+		dbp.blobLock.RUnlock()
+		if err != nil || verr != nil {
+			dbp.deleteUnusedBlob(contents.checksum)
+		}
+	}()
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO resource(filename,mime_type,size,private,created_by,updated_by,created_at,updated_at,resource_type,blob_key,checksum) VALUES(?,?,?,?,?,?,?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(meta.Filename, contents.mimeType, contents.size, meta.Private, createdBy, createdBy, ts, ts, meta.ResourceType, contents.checksum, contents.checksum)
+	if err != nil {
+		if is, field := IsDbError1452(err); is {
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
+			err = nil
+			return
+		}
+		return
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// storedContents describes contents stored by resourceStore
+type storedContents struct {
+	checksum string
+	mimeType string
+	size     int64
+}
+
+// resourceStore stores the contents read from r under their checksum, detecting their MIME type
+// if mimeType is empty, once checked they are accepted by the resource type.
+// When it stores them it returns with blobLock held for reading, which the caller releases once they
+// are referenced. Contents that end up referenced by nothing must then be removed with deleteUnusedBlob.
+func (dbp *DBProvider) resourceStore(r io.Reader, filename, mimeType string, resourceType int64) (contents *storedContents, verr ValidationErrors, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	verr = ResourceValidate(filename, mimeType, 0)
+	if verr != nil {
+		return
+	}
+	br := bufio.NewReaderSize(r, 512)
+	if mimeType == "" {
+		// Peek returns what it could read when the contents are shorter than 512 bytes
+		head, _ := br.Peek(512)
+		mimeType = detectMimeType(filename, head)
+	}
+	// the contents are spooled to a local file as the checksum is needed before storing them
+	tmp, err := ioutil.TempFile("", "instanto-upload-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), br)
+	if err != nil {
+		return
+	}
+	verr, err = dbp.resourceCheckType(resourceType, mimeType, size)
+	if err != nil || verr != nil {
+		return
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if _, err = tmp.Seek(0, 0); err != nil {
+		return
+	}
+	dbp.blobLock.RLock()
+	if _, err = dbp.putBlob(checksum, tmp); err != nil {
+		dbp.blobLock.RUnlock()
+		return
+	}
+	contents = &storedContents{checksum, mimeType, size}
+	return
+}
+
+// putBlob stores the contents read from r under key unless they are referenced and already stored.
+// It reports whether it stored them. The caller holds blobLock, so they cannot be removed meanwhile.
+func (dbp *DBProvider) putBlob(key string, r io.Reader) (stored bool, err error) {
+	refs, err := dbp.blobRefs(key)
+	if err != nil {
+		return
+	}
+	if refs != 0 {
+		// a reference does not prove the blob is there, it could have been lost from the store
+		var rc io.ReadCloser
+		rc, err = dbp.blobs.Open(key)
+		if err == nil {
+			rc.Close()
+			return
+		}
+		if err != ErrBlobNotFound {
+			return
+		}
+	}
+	if _, err = dbp.blobs.Put(key, r); err != nil {
+		return
+	}
+	stored = true
+	return
+}
+
+// resourceOpen returns the contents of the resource without checking access, callers use ResourceDownload.
+// It returns sql.ErrNoRows if the resource does not exist and ErrBlobNotFound if it has no stored contents.
+func (dbp *DBProvider) resourceOpen(id int64) (rc io.ReadCloser, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		return
+	}
+	if resource.BlobKey == "" {
+		err = ErrBlobNotFound
+		return
+	}
+	rc, err = dbp.blobs.Open(resource.BlobKey)
+	return
+}
+
+// ResourceGetByChecksum returns the resources whose contents have the SHA-256 checksum, in hexadecimal
+func (dbp *DBProvider) ResourceGetByChecksum(checksum string) (resources []*Resource, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM resource WHERE checksum=? ORDER BY id ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(strings.ToLower(strings.TrimSpace(checksum)))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Resource{}
+		err = rows.Scan(&p.Id, &p.Filename, &p.MimeType, &p.Size, &p.Private, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt, &p.ResourceType, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum))
+		if err != nil {
+			return
+		}
+		resources = append(resources, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ResourceVerify reads the stored contents of the resource and compares them with its checksum and size
+func (dbp *DBProvider) ResourceVerify(id int64) (verification *ResourceVerification, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		return
+	}
+	verification, err = dbp.resourceVerify(resource)
+	return
+}
+
+// ResourceVerifyAll verifies every resource with stored contents and returns the ones that are not ok
+func (dbp *DBProvider) ResourceVerifyAll() (failed []*ResourceVerification, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	resources, err := dbp.ResourceGetAll()
+	if err != nil {
+		return
+	}
+	for _, resource := range resources {
+		if resource.BlobKey == "" {
+			continue
+		}
+		var verification *ResourceVerification
+		verification, err = dbp.resourceVerify(resource)
+		if err != nil {
+			return
+		}
+		if verification.Status != ResourceVerifyOk {
+			failed = append(failed, verification)
+		}
+	}
+	return
+}
+
+// resourceVerify hashes the stored contents of resource
+func (dbp *DBProvider) resourceVerify(resource *Resource) (verification *ResourceVerification, err error) {
+	verification = &ResourceVerification{Resource: resource.Id}
+	if resource.BlobKey == "" {
+		verification.Status = ResourceVerifyMissing
+		return
+	}
+	rc, err := dbp.blobs.Open(resource.BlobKey)
+	if err != nil {
+		if err == ErrBlobNotFound {
+			verification.Status = ResourceVerifyMissing
+			err = nil
+		}
+		return
+	}
+	defer rc.Close()
+	hash := sha256.New()
+	verification.Size, err = io.Copy(hash, rc)
+	if err != nil {
+		return
+	}
+	verification.Checksum = hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case resource.Checksum == "":
+		verification.Status = ResourceVerifyNoChecksum
+	case verification.Checksum != resource.Checksum || verification.Size != resource.Size:
+		verification.Status = ResourceVerifyCorrupted
+	default:
+		verification.Status = ResourceVerifyOk
+	}
+	return
+}
+
+// blobRefs returns how many resources and image variants share the stored contents under key
+func (dbp *DBProvider) blobRefs(key string) (refs int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT (SELECT COUNT(id) FROM resource WHERE blob_key=?)+(SELECT COUNT(id) FROM resource_version WHERE blob_key=?)+(SELECT COUNT(id) FROM image_variant WHERE blob_key=?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(key, key, key).Scan(&refs)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// deleteUnusedBlob removes the stored contents under key once no resource, resource version or image variant references them
+func (dbp *DBProvider) deleteUnusedBlob(key string) (err error) {
+	if key == "" || dbp.blobs == nil {
+		return
+	}
+	dbp.blobLock.Lock()
+	defer dbp.blobLock.Unlock()
+	refs, err := dbp.blobRefs(key)
+	if err != nil || refs != 0 {
+		return
+	}
+	err = dbp.blobs.Delete(key)
+	return
+}
+
+// BlobCollectGarbage returns the blobs no resource or image variant references, deleting them if remove.
+// Blobs stored less than minAge ago are left alone, as they can belong to an upload in progress.
+func (dbp *DBProvider) BlobCollectGarbage(minAge time.Duration, remove bool) (unreferenced []string, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	lister, ok := dbp.blobs.(BlobLister)
+	if !ok {
+		err = ErrBlobListUnsupported
+		return
+	}
+	keys, err := lister.ListBlobs(time.Now().Add(-minAge))
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		var unused bool
+		unused, err = dbp.collectBlob(key, remove)
+		if err != nil {
+			return
+		}
+		if unused {
+			unreferenced = append(unreferenced, key)
+		}
+	}
+	return
+}
+
+// collectBlob reports whether nothing references the blob under key, deleting it if remove
+func (dbp *DBProvider) collectBlob(key string, remove bool) (unused bool, err error) {
+	dbp.blobLock.Lock()
+	defer dbp.blobLock.Unlock()
+	refs, err := dbp.blobRefs(key)
+	if err != nil || refs != 0 {
+		return
+	}
+	if remove {
+		if err = dbp.blobs.Delete(key); err != nil {
+			return
+		}
+	}
+	unused = true
+	return
+}
+
+// detectMimeType sniffs the first bytes of the contents and falls back to the extension of filename
+// when they only tell it is text or binary data
+func detectMimeType(filename string, head []byte) string {
+	detected := http.DetectContentType(head)
+	if detected != "application/octet-stream" && !strings.HasPrefix(detected, "text/plain") {
+		return detected
+	}
+	if byExtension := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExtension != "" {
+		return byExtension
+	}
+	return detected
+}
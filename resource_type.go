@@ -0,0 +1,311 @@
+package instantolib
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// ResourceType is a kind of resource, such as a logo or a report, and the files it accepts.
+// MimeTypes lists the accepted MIME types, where "image/*" accepts any image; an empty list accepts any type.
+// MaxSize is the largest accepted size in bytes, 0 for no limit.
+type ResourceType struct {
+	Id        int64    `json:"id"`
+	Name      string   `json:"name"`
+	MimeTypes []string `json:"mime_types"`
+	MaxSize   int64    `json:"max_size"`
+	CreatedBy string   `json:"created_by"`
+	UpdatedBy string   `json:"updated_by"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+func (dbp *DBProvider) ResourceTypeCreate(name string, mimeTypes []string, maxSize int64, createdBy string) (id int64, verr ValidationErrors, err error) {
+	mimeTypes = normalizeMimeTypes(mimeTypes)
+	verr = ResourceTypeValidate(name, mimeTypes, maxSize)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO resource_type(name,mime_types,max_size,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(name, strings.Join(mimeTypes, ","), maxSize, createdBy, createdBy, ts, ts)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = ValidationErrors{{"name", "this name is taken, use another", ValidationCodeDuplicate}}
+			err = nil
+			return
+		}
+		return
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ResourceTypeUpdate changes the resource type. Existing resources are not checked against the new rules.
+func (dbp *DBProvider) ResourceTypeUpdate(id int64, name string, mimeTypes []string, maxSize int64, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	mimeTypes = normalizeMimeTypes(mimeTypes)
+	verr = ResourceTypeValidate(name, mimeTypes, maxSize)
+	if verr != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "UPDATE resource_type SET name=?,mime_types=?,max_size=?,updated_by=?,updated_at=? WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	result, err := stmt.Exec(name, strings.Join(mimeTypes, ","), maxSize, updatedBy, ts, id)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = ValidationErrors{{"name", "this name is taken, use another", ValidationCodeDuplicate}}
+			err = nil
+			return
+		}
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ResourceTypeDelete(id int64) (numRows int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM resource_type WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(id)
+	if err != nil {
+		return
+	}
+	numRows, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ResourceTypeDeletePreview(id int64) (preview *DeletePreview, err error) {
+	exists, err := dbp.ResourceTypeExists(id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = sql.ErrNoRows
+		return
+	}
+	specs := []deletePreviewSpec{
+		{"resources", "resource", DeletePreviewDependent, "id", "resource_type"},
+	}
+	preview, err = dbp.deletePreview(id, specs)
+	return
+}
+func (dbp *DBProvider) ResourceTypeGetAll() (resourceTypes []*ResourceType, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM resource_type"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ResourceType{}
+		var mimeTypes string
+		err = rows.Scan(&p.Id, &p.Name, (*nullString)(&mimeTypes), &p.MaxSize, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		p.MimeTypes = splitFields(mimeTypes)
+		resourceTypes = append(resourceTypes, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ResourceTypeGetById(id int64) (resourceType *ResourceType, err error) {
+	resourceType = &ResourceType{}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM resource_type WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var mimeTypes string
+	err = stmt.QueryRow(id).Scan(&resourceType.Id, &resourceType.Name, (*nullString)(&mimeTypes), &resourceType.MaxSize, &resourceType.CreatedBy, &resourceType.UpdatedBy, &resourceType.CreatedAt, &resourceType.UpdatedAt)
+	if err != nil {
+		return
+	}
+	resourceType.MimeTypes = splitFields(mimeTypes)
+	return
+}
+func (dbp *DBProvider) ResourceTypeCount() (count int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(id) as count FROM resource_type"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow().Scan(&count)
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) ResourceTypeExists(id int64) (exists bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT COUNT(id) as count FROM resource_type WHERE id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	var count int64
+	err = stmt.QueryRow(id).Scan(&count)
+	if err != nil {
+		return
+	}
+	if count != 1 {
+		return
+	}
+	exists = true
+	return
+}
+func (dbp *DBProvider) ResourceTypeGetColumns() []string {
+	columns := []string{
+		"id",
+		"name",
+		"mime_types",
+		"max_size",
+		"created_by",
+		"updated_by",
+		"created_at",
+		"updated_at",
+	}
+	return columns
+}
+
+// Accepts checks a file of mimeType and size can be a resource of the type
+func (p *ResourceType) Accepts(mimeType string, size int64) (verrs ValidationErrors) {
+	if len(p.MimeTypes) != 0 && !mimeTypeMatches(p.MimeTypes, mimeType) {
+		verrs.add(&ValidationError{"mime_type", fmt.Sprintf("a %s must be one of %s", p.Name, strings.Join(p.MimeTypes, ", ")), ValidationCodeInvalidValue})
+	}
+	if p.MaxSize != 0 && size > p.MaxSize {
+		verrs.add(&ValidationError{"size", fmt.Sprintf("a %s cannot be larger than %d bytes", p.Name, p.MaxSize), ValidationCodeOutOfRange})
+	}
+	return
+}
+
+// resourceCheckType checks the resource type exists and accepts a file of mimeType and size
+func (dbp *DBProvider) resourceCheckType(resourceTypeId int64, mimeType string, size int64) (verr ValidationErrors, err error) {
+	resourceType, err := dbp.ResourceTypeGetById(resourceTypeId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = ValidationErrors{{"resource_type", "not exist", ValidationCodeNotFound}}
+			err = nil
+		}
+		return
+	}
+	verr = resourceType.Accepts(mimeType, size)
+	return
+}
+
+// mimeTypeMatches checks mimeType, without parameters such as charset, is one of accepted
+func mimeTypeMatches(accepted []string, mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, a := range accepted {
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+func normalizeMimeTypes(mimeTypes []string) (normalized []string) {
+	normalized = []string{}
+	for _, m := range mimeTypes {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			normalized = append(normalized, m)
+		}
+	}
+	return
+}
+func resourceTypeValidateName(name string) (verr *ValidationError) {
+	if verr = validateNotEmpty("name", name); verr != nil {
+		return verr
+	}
+	return validateLength("name", name, 200)
+}
+func resourceTypeValidateMimeTypes(mimeTypes []string) (verr *ValidationError) {
+	for _, m := range mimeTypes {
+		parts := strings.Split(m, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[0] == "*" || parts[1] == "" || strings.ContainsAny(m, ",; ") {
+			return &ValidationError{"mime_types", m + " is not a MIME type such as image/png or image/*", ValidationCodeInvalidFormat}
+		}
+	}
+	return validateLength("mime_types", strings.Join(mimeTypes, ","), 500)
+}
+func resourceTypeValidateMaxSize(maxSize int64) (verr *ValidationError) {
+	return validateIsNumber("max_size", maxSize)
+}
+func ResourceTypeValidate(name string, mimeTypes []string, maxSize int64) (verrs ValidationErrors) {
+	verrs.add(resourceTypeValidateName(name))
+	verrs.add(resourceTypeValidateMimeTypes(mimeTypes))
+	verrs.add(resourceTypeValidateMaxSize(maxSize))
+	return
+}
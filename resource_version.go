@@ -0,0 +1,252 @@
+package instantolib
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// ResourceVersion is a version of the contents of a resource. Previous versions are numbered from 1
+// in the order they were replaced, and the current version, the one in the resource, comes last.
+type ResourceVersion struct {
+	Id        int64  `json:"id"`
+	Resource  int64  `json:"resource"`
+	Version   int64  `json:"version"`
+	Filename  string `json:"filename"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	BlobKey   string `json:"-"`
+	Checksum  string `json:"checksum"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+	Current   bool   `json:"current"`
+}
+
+// ResourceUploadVersion replaces the contents of a resource with the ones read from r, keeping the
+// replaced contents as a previous version. The MIME type is detected as in ResourceUpload.
+func (dbp *DBProvider) ResourceUploadVersion(id int64, r io.Reader, filename, updatedBy string) (version int64, verr ValidationErrors, err error) {
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = ValidationErrors{{"resource", "not exist", ValidationCodeNotFound}}
+			err = nil
+		}
+		return
+	}
+	contents, verr, err := dbp.resourceStore(r, filename, "", resource.ResourceType)
+	if err != nil || verr != nil {
+		return
+	}
+	defer func() {
+		dbp.blobLock.RUnlock()
+		if err != nil {
+			dbp.deleteUnusedBlob(contents.checksum)
+		}
+	}()
+	current := &ResourceVersion{Filename: filename, MimeType: contents.mimeType, Size: contents.size, BlobKey: contents.checksum, Checksum: contents.checksum}
+	version, err = dbp.resourceReplaceVersion(id, current, updatedBy)
+	return
+}
+
+// ResourceRestoreVersion makes a previous version the current one again. The replaced current version
+// is kept as a previous version, so restoring never loses contents.
+func (dbp *DBProvider) ResourceRestoreVersion(id, version int64, updatedBy string) (newVersion int64, verr *ValidationError, err error) {
+	previous, err := dbp.ResourceGetVersion(id, version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			verr = &ValidationError{"version", "not exist", ValidationCodeNotFound}
+			err = nil
+		}
+		return
+	}
+	if previous.Current {
+		verr = &ValidationError{"version", "is already the current version", ValidationCodeConflict}
+		return
+	}
+	newVersion, err = dbp.resourceReplaceVersion(id, previous, updatedBy)
+	return
+}
+
+// resourceReplaceVersion keeps the current contents of the resource as a previous version and replaces them
+// with the ones of current. It returns the number of the new current version.
+func (dbp *DBProvider) resourceReplaceVersion(id int64, current *ResourceVersion, updatedBy string) (version int64, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	version, err = txResourceReplaceVersion(tx, id, current, updatedBy, time.Now().Unix())
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// txResourceReplaceVersion is resourceReplaceVersion inside tx
+func txResourceReplaceVersion(tx *sql.Tx, id int64, current *ResourceVersion, updatedBy string, ts int64) (version int64, err error) {
+	p := ResourceVersion{}
+	err = tx.QueryRow("SELECT filename,mime_type,size,blob_key,checksum,updated_by,updated_at FROM resource WHERE id=? FOR UPDATE", id).Scan(&p.Filename, &p.MimeType, &p.Size, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum), &p.CreatedBy, &p.CreatedAt)
+	if err != nil {
+		return
+	}
+	err = tx.QueryRow("SELECT COALESCE(MAX(version),0)+1 FROM resource_version WHERE resource=?", id).Scan(&version)
+	if err != nil {
+		return
+	}
+	// the replaced version was created by whoever last changed the resource
+	_, err = txExec(tx, "INSERT INTO resource_version(resource,version,filename,mime_type,size,blob_key,checksum,created_by,created_at) VALUES(?,?,?,?,?,?,?,?,?)", id, version, p.Filename, p.MimeType, p.Size, nullIfEmpty(p.BlobKey), nullIfEmpty(p.Checksum), p.CreatedBy, p.CreatedAt)
+	if err != nil {
+		return
+	}
+	_, err = txExec(tx, "UPDATE resource SET filename=?,mime_type=?,size=?,blob_key=?,checksum=?,updated_by=?,updated_at=? WHERE id=?", current.Filename, current.MimeType, current.Size, nullIfEmpty(current.BlobKey), nullIfEmpty(current.Checksum), updatedBy, ts, id)
+	if err != nil {
+		return
+	}
+	version++
+	return
+}
+
+// ResourceGetVersions returns every version of the resource, oldest first and the current one last
+func (dbp *DBProvider) ResourceGetVersions(id int64) (versions []*ResourceVersion, err error) {
+	resource, err := dbp.ResourceGetById(id)
+	if err != nil {
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM resource_version WHERE resource=? ORDER BY version ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ResourceVersion{}
+		err = rows.Scan(&p.Id, &p.Resource, &p.Version, &p.Filename, &p.MimeType, &p.Size, (*nullString)(&p.BlobKey), (*nullString)(&p.Checksum), &p.CreatedBy, &p.CreatedAt)
+		if err != nil {
+			return
+		}
+		versions = append(versions, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	versions = append(versions, &ResourceVersion{
+		Resource:  resource.Id,
+		Version:   int64(len(versions)) + 1,
+		Filename:  resource.Filename,
+		MimeType:  resource.MimeType,
+		Size:      resource.Size,
+		BlobKey:   resource.BlobKey,
+		Checksum:  resource.Checksum,
+		CreatedBy: resource.UpdatedBy,
+		CreatedAt: resource.UpdatedAt,
+		Current:   true,
+	})
+	return
+}
+
+// ResourceGetVersion returns a version of the resource, or sql.ErrNoRows if it does not exist
+func (dbp *DBProvider) ResourceGetVersion(id, version int64) (resourceVersion *ResourceVersion, err error) {
+	versions, err := dbp.ResourceGetVersions(id)
+	if err != nil {
+		return
+	}
+	if version < 1 || version > int64(len(versions)) {
+		err = sql.ErrNoRows
+		return
+	}
+	resourceVersion = versions[version-1]
+	return
+}
+
+// resourceOpenVersion is resourceOpen for a version of the resource, callers use ResourceDownloadVersion
+func (dbp *DBProvider) resourceOpenVersion(id, version int64) (rc io.ReadCloser, err error) {
+	if dbp.blobs == nil {
+		err = ErrNoBlobStore
+		return
+	}
+	resourceVersion, err := dbp.ResourceGetVersion(id, version)
+	if err != nil {
+		return
+	}
+	if resourceVersion.BlobKey == "" {
+		err = ErrBlobNotFound
+		return
+	}
+	rc, err = dbp.blobs.Open(resourceVersion.BlobKey)
+	return
+}
+
+// ResourceDownloadVersion is ResourceDownload for a version of the resource
+func (dbp *DBProvider) ResourceDownloadVersion(id, version int64, token, username, remoteAddr string) (rc io.ReadCloser, resourceVersion *ResourceVersion, err error) {
+	resource, err := dbp.ResourceCheckAccess(id, token, username)
+	if err != nil {
+		return
+	}
+	resourceVersion, err = dbp.ResourceGetVersion(id, version)
+	if err != nil {
+		return
+	}
+	rc, err = dbp.resourceOpenVersion(id, version)
+	if err != nil {
+		return
+	}
+	if resource.Private {
+		err = dbp.resourceLogDownload(id, username, remoteAddr)
+		if err != nil {
+			rc.Close()
+			rc = nil
+			return
+		}
+	}
+	return
+}
+
+// txResourceVersionDeleteAll removes the previous versions of a resource being deleted and returns their blob keys
+func txResourceVersionDeleteAll(tx *sql.Tx, id int64) (keys []string, err error) {
+	rows, err := tx.Query("SELECT blob_key FROM resource_version WHERE resource=? AND blob_key IS NOT NULL", id)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			rows.Close()
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+	_, err = txExec(tx, "DELETE FROM resource_version WHERE resource=?", id)
+	return
+}
+
+func (dbp *DBProvider) ResourceVersionGetColumns() []string {
+	return []string{"id", "resource", "version", "filename", "mime_type", "size", "blob_key", "checksum", "created_by", "created_at"}
+}
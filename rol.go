@@ -10,8 +10,8 @@ type Rol struct {
 	Description string `json:"description"`
 }
 
-func (dbp *DBProvider) RolCreate(id, displayName, description string) (verr *ValidationError, err error) {
-	verr = rolValidate(displayName, description)
+func (dbp *DBProvider) RolCreate(id, displayName, description string) (verr ValidationErrors, err error) {
+	verr = RolValidate(displayName, description)
 	if verr != nil {
 		return
 	}
@@ -29,12 +29,12 @@ func (dbp *DBProvider) RolCreate(id, displayName, description string) (verr *Val
 	_, err = stmt.Exec(id, displayName, description)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"id", "this id is taken, use another"}
+			verr = ValidationErrors{{"id", "this id is taken, use another", ValidationCodeDuplicate}}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -42,8 +42,8 @@ func (dbp *DBProvider) RolCreate(id, displayName, description string) (verr *Val
 	}
 	return
 }
-func (dbp *DBProvider) RolUpdate(id, displayName, description string) (numRows int64, verr *ValidationError, err error) {
-	verr = rolValidate(displayName, description)
+func (dbp *DBProvider) RolUpdate(id, displayName, description string) (numRows int64, verr ValidationErrors, err error) {
+	verr = RolValidate(displayName, description)
 	if verr != nil {
 		return
 	}
@@ -61,7 +61,7 @@ func (dbp *DBProvider) RolUpdate(id, displayName, description string) (numRows i
 	result, err := stmt.Exec(displayName, description, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -186,6 +186,97 @@ func (dbp *DBProvider) RolExists(id string) (exists bool, err error) {
 	exists = true
 	return
 }
+func (dbp *DBProvider) RolAddPermission(id, permissionId string) (verr *ValidationError, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO rol_permission(rol,permission) VALUES(?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id, permissionId)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = &ValidationError{"permission", "this permission has already been added", ValidationCodeDuplicate}
+			err = nil
+			return
+		}
+		if is, field := IsDbError1452(err); is {
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
+			err = nil
+			return
+		}
+		return
+	}
+	return
+}
+func (dbp *DBProvider) RolRemovePermission(id, permissionId string) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM rol_permission WHERE rol=? AND permission=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(id, permissionId)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+func (dbp *DBProvider) RolGetPermissions(id string) (permissions []*Permission, err error) {
+	permissions, err = dbp.PermissionGetByRol(id)
+	return
+}
+
+// RolGetByUGroup returns the roles granted to the members of a group
+func (dbp *DBProvider) RolGetByUGroup(ugroupId string) (rols []*Rol, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT rol.* FROM ugroup_rol INNER JOIN rol ON ugroup_rol.rol=rol.id WHERE ugroup=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(ugroupId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Rol{}
+		err = rows.Scan(&p.Id, &p.DisplayName, &p.Description)
+		if err != nil {
+			return
+		}
+		rols = append(rols, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
 func (dbp *DBProvider) RolGetColumns() []string {
 	columns := []string{
 		"id",
@@ -196,34 +287,28 @@ func (dbp *DBProvider) RolGetColumns() []string {
 }
 func rolValidateDisplayName(displayName string) (verr *ValidationError) {
 	if len(displayName) == 0 {
-		verr = &ValidationError{"display_name", "cannot be empty"}
+		verr = &ValidationError{"display_name", "cannot be empty", ValidationCodeRequired}
 		return
 	}
 	if len(displayName) > 45 {
-		verr = &ValidationError{"display_name", "length cannot be greater than 45"}
+		verr = &ValidationError{"display_name", "length cannot be greater than 45", ValidationCodeTooLong}
 		return
 	}
 	return
 }
 func rolValidateDescription(description string) (verr *ValidationError) {
 	if len(description) == 0 {
-		verr = &ValidationError{"description", "cannot be empty"}
+		verr = &ValidationError{"description", "cannot be empty", ValidationCodeRequired}
 		return
 	}
 	if len(description) > 200 {
-		verr = &ValidationError{"description", "length cannot be greater than 200"}
+		verr = &ValidationError{"description", "length cannot be greater than 200", ValidationCodeTooLong}
 		return
 	}
 	return
 }
-func rolValidate(displayName, description string) (verr *ValidationError) {
-	verr = rolValidateDisplayName(displayName)
-	if verr != nil {
-		return
-	}
-	verr = rolValidateDescription(description)
-	if verr != nil {
-		return
-	}
+func RolValidate(displayName, description string) (verrs ValidationErrors) {
+	verrs.add(rolValidateDisplayName(displayName))
+	verrs.add(rolValidateDescription(description))
 	return
 }
@@ -18,8 +18,8 @@ type Status struct {
 	RelMemberCreatedAt string `json:"member_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) StatusCreate(name, description, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = statusValidate(name, description)
+func (dbp *DBProvider) StatusCreate(name, description, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = StatusValidate(name, description)
 	if verr != nil {
 		return
 	}
@@ -38,7 +38,7 @@ func (dbp *DBProvider) StatusCreate(name, description, createdBy string) (id int
 	result, err := stmt.Exec(name, description, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -50,8 +50,8 @@ func (dbp *DBProvider) StatusCreate(name, description, createdBy string) (id int
 	}
 	return
 }
-func (dbp *DBProvider) StatusUpdate(id int64, name, description, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = statusValidate(name, description)
+func (dbp *DBProvider) StatusUpdate(id int64, name, description, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = StatusValidate(name, description)
 	if verr != nil {
 		return
 	}
@@ -70,7 +70,7 @@ func (dbp *DBProvider) StatusUpdate(id int64, name, description, updatedBy strin
 	result, err := stmt.Exec(name, description, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -102,9 +102,12 @@ func (dbp *DBProvider) StatusDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.translationDeleteAll(EntityTypeStatus, id)
+	}
 	return
 }
-func (dbp *DBProvider) StatusGetAll() (statuss []*Status, err error) {
+func (dbp *DBProvider) StatusGetAll(opts ...QueryOption) (statuss []*Status, err error) {
 	db, err := dbp.getDB()
 	if err != nil {
 		return
@@ -133,9 +136,14 @@ func (dbp *DBProvider) StatusGetAll() (statuss []*Status, err error) {
 	if err != nil {
 		return
 	}
+	items := make([]translatable, len(statuss))
+	for i, p := range statuss {
+		items[i] = p
+	}
+	err = dbp.translate(EntityTypeStatus, items, opts)
 	return
 }
-func (dbp *DBProvider) StatusGetById(id int64) (status *Status, err error) {
+func (dbp *DBProvider) StatusGetById(id int64, opts ...QueryOption) (status *Status, err error) {
 	status = &Status{}
 	db, err := dbp.getDB()
 	if err != nil {
@@ -152,6 +160,7 @@ func (dbp *DBProvider) StatusGetById(id int64) (status *Status, err error) {
 	if err != nil {
 		return
 	}
+	err = dbp.translate(EntityTypeStatus, []translatable{status}, opts)
 	return
 }
 func (dbp *DBProvider) StatusGetByMember(memberId int64) (statuses []*Status, err error) {
@@ -232,7 +241,7 @@ func (dbp *DBProvider) StatusAddMember(id, memberId int64, createdBy string) (ve
 		return
 	}
 	if member.PrimaryStatus == id {
-		verr = &ValidationError{"member", "this member has this status as primary"}
+		verr = &ValidationError{"member", "this member has this status as primary", ValidationCodeConflict}
 		return
 	}
 	db, err := dbp.getDB()
@@ -250,12 +259,12 @@ func (dbp *DBProvider) StatusAddMember(id, memberId int64, createdBy string) (ve
 	_, err = stmt.Exec(memberId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"member", "this member has already been added"}
+			verr = &ValidationError{"member", "this member has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -315,14 +324,8 @@ func statusValidateName(name string) (verr *ValidationError) {
 func statusValidateDescription(description string) (verr *ValidationError) {
 	return validateLength("description", description, 200)
 }
-func statusValidate(name, description string) (verr *ValidationError) {
-	verr = statusValidateName(name)
-	if verr != nil {
-		return
-	}
-	verr = statusValidateDescription(description)
-	if verr != nil {
-		return
-	}
+func StatusValidate(name, description string) (verrs ValidationErrors) {
+	verrs.add(statusValidateName(name))
+	verrs.add(statusValidateDescription(description))
 	return
 }
@@ -22,8 +22,8 @@ type StudentWork struct {
 	RelResearchLineCreatedAt int64  `json:"research_line_created_at,omitempty"`
 }
 
-func (dbp *DBProvider) StudentWorkCreate(title string, year int64, school, volume, createdBy string, studentWorkType, author int64) (id int64, verr *ValidationError, err error) {
-	verr = studentWorkValidate(title, year, school, volume)
+func (dbp *DBProvider) StudentWorkCreate(title string, year int64, school, volume, createdBy string, studentWorkType, author int64) (id int64, verr ValidationErrors, err error) {
+	verr = StudentWorkValidate(title, year, school, volume)
 	if verr != nil {
 		return
 	}
@@ -42,7 +42,7 @@ func (dbp *DBProvider) StudentWorkCreate(title string, year int64, school, volum
 	result, err := stmt.Exec(title, year, school, volume, createdBy, createdBy, ts, ts, studentWorkType, author)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -54,8 +54,8 @@ func (dbp *DBProvider) StudentWorkCreate(title string, year int64, school, volum
 	}
 	return
 }
-func (dbp *DBProvider) StudentWorkUpdate(id int64, title string, year int64, school, volume, updatedBy string, studentWorkType, author int64) (numRows int64, verr *ValidationError, err error) {
-	verr = studentWorkValidate(title, year, school, volume)
+func (dbp *DBProvider) StudentWorkUpdate(id int64, title string, year int64, school, volume, updatedBy string, studentWorkType, author int64) (numRows int64, verr ValidationErrors, err error) {
+	verr = StudentWorkValidate(title, year, school, volume)
 	if verr != nil {
 		return
 	}
@@ -74,7 +74,7 @@ func (dbp *DBProvider) StudentWorkUpdate(id int64, title string, year int64, sch
 	result, err := stmt.Exec(title, year, school, volume, updatedBy, ts, studentWorkType, author, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -106,6 +106,9 @@ func (dbp *DBProvider) StudentWorkDelete(id int64) (numRows int64, err error) {
 	if err != nil {
 		return
 	}
+	if numRows != 0 {
+		err = dbp.attachmentDeleteAll(EntityTypeStudentWork, id)
+	}
 	return
 }
 func (dbp *DBProvider) StudentWorkGetAll() (studentWorks []*StudentWork, err error) {
@@ -308,12 +311,12 @@ func (dbp *DBProvider) StudentWorkAddResearchLine(id, researchLineId int64, crea
 	_, err = stmt.Exec(researchLineId, id, createdBy, ts)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"research_line", "this research_line has already been added"}
+			verr = &ValidationError{"research_line", "this research_line has already been added", ValidationCodeDuplicate}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
 			err = nil
 			return
 		}
@@ -374,7 +377,7 @@ func studentWorkValidateTitle(title string) (verr *ValidationError) {
 	return validateLength("title", title, 200)
 }
 func studentWorkValidateYear(year int64) (verr *ValidationError) {
-	return validateIsNumber("year", year)
+	return validateYear("year", year)
 }
 func studentWorkValidateSchool(school string) (verr *ValidationError) {
 	return validateLength("school", school, 200)
@@ -382,22 +385,10 @@ func studentWorkValidateSchool(school string) (verr *ValidationError) {
 func studentWorkValidateVolume(volume string) (verr *ValidationError) {
 	return validateLength("volume", volume, 200)
 }
-func studentWorkValidate(title string, year int64, school, volume string) (verr *ValidationError) {
-	verr = studentWorkValidateTitle(title)
-	if verr != nil {
-		return
-	}
-	verr = studentWorkValidateYear(year)
-	if verr != nil {
-		return
-	}
-	verr = studentWorkValidateSchool(school)
-	if verr != nil {
-		return
-	}
-	verr = studentWorkValidateVolume(volume)
-	if verr != nil {
-		return
-	}
+func StudentWorkValidate(title string, year int64, school, volume string) (verrs ValidationErrors) {
+	verrs.add(studentWorkValidateTitle(title))
+	verrs.add(studentWorkValidateYear(year))
+	verrs.add(studentWorkValidateSchool(school))
+	verrs.add(studentWorkValidateVolume(volume))
 	return
 }
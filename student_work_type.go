@@ -15,8 +15,8 @@ type StudentWorkType struct {
 	UpdatedAt int64  `json:"updated_at"`
 }
 
-func (dbp *DBProvider) StudentWorkTypeCreate(name, createdBy string) (id int64, verr *ValidationError, err error) {
-	verr = studentWorkTypeValidate(name)
+func (dbp *DBProvider) StudentWorkTypeCreate(name, createdBy string) (id int64, verr ValidationErrors, err error) {
+	verr = StudentWorkTypeValidate(name)
 	if verr != nil {
 		return
 	}
@@ -35,7 +35,7 @@ func (dbp *DBProvider) StudentWorkTypeCreate(name, createdBy string) (id int64,
 	result, err := stmt.Exec(name, createdBy, createdBy, ts, ts)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -47,8 +47,8 @@ func (dbp *DBProvider) StudentWorkTypeCreate(name, createdBy string) (id int64,
 	}
 	return
 }
-func (dbp *DBProvider) StudentWorkTypeUpdate(id int64, name, updatedBy string) (numRows int64, verr *ValidationError, err error) {
-	verr = studentWorkTypeValidate(name)
+func (dbp *DBProvider) StudentWorkTypeUpdate(id int64, name, updatedBy string) (numRows int64, verr ValidationErrors, err error) {
+	verr = StudentWorkTypeValidate(name)
 	if verr != nil {
 		return
 	}
@@ -67,7 +67,7 @@ func (dbp *DBProvider) StudentWorkTypeUpdate(id int64, name, updatedBy string) (
 	result, err := stmt.Exec(name, updatedBy, ts, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -210,10 +210,7 @@ func studentWorkTypeValidateName(name string) (verr *ValidationError) {
 	return validateLength("name", name, 200)
 }
 
-func studentWorkTypeValidate(name string) (verr *ValidationError) {
-	verr = studentWorkTypeValidateName(name)
-	if verr != nil {
-		return
-	}
+func StudentWorkTypeValidate(name string) (verrs ValidationErrors) {
+	verrs.add(studentWorkTypeValidateName(name))
 	return
 }
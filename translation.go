@@ -0,0 +1,378 @@
+package instantolib
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Translatable entity types besides the ones in entityTables
+const (
+	EntityTypeStatus          = "status"
+	EntityTypeCategory        = "category"
+	EntityTypeResearchArea    = "research_area"
+	EntityTypePublicationType = "publication_type"
+)
+
+// translatableFields lists the columns that can be translated for every translatable entity type.
+// The columns hold the text in the original language, which is used when no translation applies.
+var translatableFields = map[string][]string{
+	EntityTypeResearchLine:    {"title", "description"},
+	EntityTypeStatus:          {"name", "description"},
+	EntityTypeCategory:        {"name", "description"},
+	EntityTypeResearchArea:    {"name"},
+	EntityTypePublicationType: {"name"},
+}
+
+var localeRegexp = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+type Translation struct {
+	Id         int64  `json:"id"`
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Field      string `json:"field"`
+	Locale     string `json:"locale"`
+	Value      string `json:"value"`
+	CreatedBy  string `json:"created_by"`
+	UpdatedBy  string `json:"updated_by"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+// MissingTranslation is a non empty field of an entity that has no translation for a locale
+type MissingTranslation struct {
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Field      string `json:"field"`
+}
+
+// QueryOption changes how the getters that accept it read entities
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	locales []string
+}
+
+// WithLocale makes getters return the translations for locale of the translatable fields.
+// A missing translation falls back to the language of locale ("es" for "es-AR"),
+// then to each of fallbacks in order and at last to the original text.
+func WithLocale(locale string, fallbacks ...string) QueryOption {
+	return func(o *queryOptions) {
+		o.locales = nil
+		for _, l := range append([]string{locale}, fallbacks...) {
+			l = NormalizeLocale(l)
+			if l == "" {
+				continue
+			}
+			o.locales = appendLocale(o.locales, l)
+			if i := strings.Index(l, "-"); i != -1 {
+				o.locales = appendLocale(o.locales, l[:i])
+			}
+		}
+	}
+}
+
+func appendLocale(locales []string, locale string) []string {
+	for _, l := range locales {
+		if l == locale {
+			return locales
+		}
+	}
+	return append(locales, locale)
+}
+
+func newQueryOptions(opts []QueryOption) *queryOptions {
+	o := &queryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NormalizeLocale returns locale as a lowercase language and an optional uppercase region, "en_us" becomes "en-US"
+func NormalizeLocale(locale string) string {
+	locale = strings.Replace(strings.TrimSpace(locale), "_", "-", -1)
+	parts := strings.SplitN(locale, "-", 2)
+	if len(parts) == 2 {
+		return strings.ToLower(parts[0]) + "-" + strings.ToUpper(parts[1])
+	}
+	return strings.ToLower(locale)
+}
+
+// translatable is implemented by the entities with translatable fields
+type translatable interface {
+	translationId() int64
+	translationFields() map[string]*string
+}
+
+func (p *ResearchLine) translationId() int64 { return p.Id }
+func (p *ResearchLine) translationFields() map[string]*string {
+	return map[string]*string{"title": &p.Title, "description": &p.Description}
+}
+func (p *Status) translationId() int64 { return p.Id }
+func (p *Status) translationFields() map[string]*string {
+	return map[string]*string{"name": &p.Name, "description": &p.Description}
+}
+func (p *Category) translationId() int64 { return p.Id }
+func (p *Category) translationFields() map[string]*string {
+	return map[string]*string{"name": &p.Name, "description": &p.Description}
+}
+func (p *ResearchArea) translationId() int64 { return p.Id }
+func (p *ResearchArea) translationFields() map[string]*string {
+	return map[string]*string{"name": &p.Name}
+}
+func (p *PublicationType) translationId() int64 { return p.Id }
+func (p *PublicationType) translationFields() map[string]*string {
+	return map[string]*string{"name": &p.Name}
+}
+
+// translate replaces the translatable fields of items with the best translation for the locales of opts
+func (dbp *DBProvider) translate(entityType string, items []translatable, opts []QueryOption) (err error) {
+	o := newQueryOptions(opts)
+	if len(o.locales) == 0 || len(items) == 0 {
+		return
+	}
+	byId := make(map[int64]translatable)
+	args := []interface{}{entityType}
+	for _, locale := range o.locales {
+		args = append(args, locale)
+	}
+	for _, item := range items {
+		byId[item.translationId()] = item
+		args = append(args, item.translationId())
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT entity_id,field,locale,value FROM translation WHERE entity_type=? AND locale IN (" + sqlPlaceholders(len(o.locales)) + ") AND entity_id IN (" + sqlPlaceholders(len(items)) + ")"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	rank := make(map[string]int)
+	for i, locale := range o.locales {
+		rank[locale] = i
+	}
+	type key struct {
+		id    int64
+		field string
+	}
+	best := make(map[key]int)
+	for rows.Next() {
+		var id int64
+		var field, locale, value string
+		err = rows.Scan(&id, &field, &locale, &value)
+		if err != nil {
+			return
+		}
+		k := key{id, field}
+		if r, ok := best[k]; ok && r <= rank[locale] {
+			continue
+		}
+		dst, ok := byId[id].translationFields()[field]
+		if !ok {
+			continue
+		}
+		best[k] = rank[locale]
+		*dst = value
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// TranslationSet creates or replaces the translation for locale of a field of an entity
+func (dbp *DBProvider) TranslationSet(entityType string, id int64, field, locale, value, updatedBy string) (verr ValidationErrors, err error) {
+	locale = NormalizeLocale(locale)
+	verr = TranslationValidate(entityType, field, locale, value)
+	if verr != nil {
+		return
+	}
+	exists, err := dbp.entityExistsIn(entityType, id)
+	if err != nil {
+		return
+	}
+	if !exists {
+		verr = ValidationErrors{{entityType, "not exist", ValidationCodeNotFound}}
+		return
+	}
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO translation(entity_type,entity_id,field,locale,value,created_by,updated_by,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE value=VALUES(value),updated_by=VALUES(updated_by),updated_at=VALUES(updated_at)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	ts := time.Now().Unix()
+	_, err = stmt.Exec(entityType, id, field, locale, value, updatedBy, updatedBy, ts, ts)
+	if err != nil {
+		return
+	}
+	return
+}
+func (dbp *DBProvider) TranslationDelete(entityType string, id int64, field, locale string) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM translation WHERE entity_type=? AND entity_id=? AND field=? AND locale=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(entityType, id, field, NormalizeLocale(locale))
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+// translationDeleteAll removes the translations of a deleted entity, which no foreign key removes
+func (dbp *DBProvider) translationDeleteAll(entityType string, id int64) (err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM translation WHERE entity_type=? AND entity_id=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(entityType, id)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// TranslationGetByEntity returns every translation of an entity
+func (dbp *DBProvider) TranslationGetByEntity(entityType string, id int64) (translations []*Translation, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "SELECT * FROM translation WHERE entity_type=? AND entity_id=? ORDER BY field ASC,locale ASC"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(entityType, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Translation{}
+		err = rows.Scan(&p.Id, &p.EntityType, &p.EntityId, &p.Field, &p.Locale, &p.Value, &p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return
+		}
+		translations = append(translations, &p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// TranslationGetMissing returns the non empty translatable fields of every entity that have no translation for locale
+func (dbp *DBProvider) TranslationGetMissing(locale string) (missing []*MissingTranslation, err error) {
+	locale = NormalizeLocale(locale)
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	for _, entityType := range []string{EntityTypeResearchLine, EntityTypeStatus, EntityTypeCategory, EntityTypeResearchArea, EntityTypePublicationType} {
+		for _, field := range translatableFields[entityType] {
+			// entityType and field come from translatableFields, never from the caller
+			query := "SELECT " + entityType + ".id FROM " + entityType + " LEFT JOIN translation ON translation.entity_type=? AND translation.entity_id=" + entityType + ".id AND translation.field=? AND translation.locale=? WHERE translation.id IS NULL AND " + entityType + "." + field + "<>'' ORDER BY " + entityType + ".id ASC"
+			var ids []int64
+			ids, err = queryIds(db, query, entityType, field, locale)
+			if err != nil {
+				return
+			}
+			for _, id := range ids {
+				missing = append(missing, &MissingTranslation{entityType, id, field})
+			}
+		}
+	}
+	return
+}
+
+// entityExistsIn checks the entity of any translatable entity type exists
+func (dbp *DBProvider) entityExistsIn(entityType string, id int64) (exists bool, err error) {
+	switch entityType {
+	case EntityTypeStatus:
+		return dbp.StatusExists(id)
+	case EntityTypeCategory:
+		return dbp.CategoryExists(id)
+	case EntityTypeResearchArea:
+		return dbp.ResearchAreaExists(id)
+	case EntityTypePublicationType:
+		return dbp.PublicationTypeExists(id)
+	}
+	return dbp.entityExists(entityType, id)
+}
+
+func (dbp *DBProvider) TranslationGetColumns() []string {
+	return []string{"id", "entity_type", "entity_id", "field", "locale", "value", "created_by", "updated_by", "created_at", "updated_at"}
+}
+
+func validateLocale(field, locale string) *ValidationError {
+	if !localeRegexp.MatchString(locale) {
+		return &ValidationError{field, "must be a language code with an optional region, such as es or en-GB", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+func translationValidateField(entityType, field string) (verr *ValidationError) {
+	fields, ok := translatableFields[entityType]
+	if !ok {
+		return &ValidationError{"entity_type", "cannot be translated", ValidationCodeInvalidValue}
+	}
+	for _, f := range fields {
+		if f == field {
+			return nil
+		}
+	}
+	return &ValidationError{"field", "must be one of " + strings.Join(fields, ", "), ValidationCodeInvalidValue}
+}
+func translationValidateValue(value string) (verr *ValidationError) {
+	return validateNotEmpty("value", value)
+}
+func TranslationValidate(entityType, field, locale, value string) (verrs ValidationErrors) {
+	verrs.add(translationValidateField(entityType, field))
+	verrs.add(validateLocale("locale", locale))
+	verrs.add(translationValidateValue(value))
+	return
+}
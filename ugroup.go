@@ -9,8 +9,8 @@ type UGroup struct {
 	DisplayName string `json:"display_name"`
 }
 
-func (dbp *DBProvider) UGroupCreate(id, displayName string) (verr *ValidationError, err error) {
-	verr = uGroupValidate(displayName)
+func (dbp *DBProvider) UGroupCreate(id, displayName string) (verr ValidationErrors, err error) {
+	verr = UGroupValidate(displayName)
 	if verr != nil {
 		return
 	}
@@ -28,12 +28,12 @@ func (dbp *DBProvider) UGroupCreate(id, displayName string) (verr *ValidationErr
 	_, err = stmt.Exec(id, displayName)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"id", "this id is taken, use another"}
+			verr = ValidationErrors{{"id", "this id is taken, use another", ValidationCodeDuplicate}}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -41,8 +41,8 @@ func (dbp *DBProvider) UGroupCreate(id, displayName string) (verr *ValidationErr
 	}
 	return
 }
-func (dbp *DBProvider) UGroupUpdate(id, display_name string) (numRows int64, verr *ValidationError, err error) {
-	verr = uGroupValidate(display_name)
+func (dbp *DBProvider) UGroupUpdate(id, display_name string) (numRows int64, verr ValidationErrors, err error) {
+	verr = UGroupValidate(display_name)
 	if verr != nil {
 		return
 	}
@@ -60,7 +60,7 @@ func (dbp *DBProvider) UGroupUpdate(id, display_name string) (numRows int64, ver
 	result, err := stmt.Exec(display_name, id)
 	if err != nil {
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exists"}
+			verr = ValidationErrors{{field, "not exists", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
@@ -185,6 +185,66 @@ func (dbp *DBProvider) UGroupExists(id string) (exists bool, err error) {
 	exists = true
 	return
 }
+
+// UGroupAddRol grants a role, and so its permissions, to the users of the group
+func (dbp *DBProvider) UGroupAddRol(id, rolId string) (verr *ValidationError, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "INSERT INTO ugroup_rol(ugroup,rol) VALUES(?,?)"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id, rolId)
+	if err != nil {
+		if IsDbError1062(err) {
+			verr = &ValidationError{"rol", "this rol has already been added", ValidationCodeDuplicate}
+			err = nil
+			return
+		}
+		if is, field := IsDbError1452(err); is {
+			verr = &ValidationError{field, "not exist", ValidationCodeNotFound}
+			err = nil
+			return
+		}
+		return
+	}
+	return
+}
+func (dbp *DBProvider) UGroupRemoveRol(id, rolId string) (removed bool, err error) {
+	db, err := dbp.getDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	query := "DELETE FROM ugroup_rol WHERE ugroup=? AND rol=?"
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(id, rolId)
+	if err != nil {
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if numRows != 0 {
+		removed = true
+	}
+	return
+}
+
+func (dbp *DBProvider) UGroupGetRols(id string) (rols []*Rol, err error) {
+	rols, err = dbp.RolGetByUGroup(id)
+	return
+}
 func (dbp *DBProvider) UGroupGetColumns() []string {
 	columns := []string{
 		"id",
@@ -198,10 +258,7 @@ func uGroupValidateDisplayName(displayName string) (verr *ValidationError) {
 	}
 	return validateLength("display_name", displayName, 200)
 }
-func uGroupValidate(displayName string) (verr *ValidationError) {
-	verr = uGroupValidateDisplayName(displayName)
-	if verr != nil {
-		return
-	}
+func UGroupValidate(displayName string) (verrs ValidationErrors) {
+	verrs.add(uGroupValidateDisplayName(displayName))
 	return
 }
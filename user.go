@@ -15,8 +15,8 @@ type User struct {
 	UGroup      string `json:"ugroup"`
 }
 
-func (dbp *DBProvider) UserCreate(username, email, password string, enabled bool, displayName, ugroup string) (ok bool, verr *ValidationError, err error) {
-	verr = userValidate(username, email, password, displayName)
+func (dbp *DBProvider) UserCreate(username, email, password string, enabled bool, displayName, ugroup string) (ok bool, verr ValidationErrors, err error) {
+	verr = UserValidate(username, email, password, displayName)
 	if verr != nil {
 		return
 	}
@@ -31,24 +31,24 @@ func (dbp *DBProvider) UserCreate(username, email, password string, enabled bool
 	defer db.Close()
 	query := "INSERT INTO user(username,email,password,enabled,display_name,ugroup) VALUES(?,?,?,?,?,?)"
 	stmt, err := db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(username, email /*string(hashedPassword)*/, password, enabled, displayName, ugroup)
 	if err != nil {
 		if IsDbError1062(err) {
-			verr = &ValidationError{"username", "this username is taken, use another"}
+			verr = ValidationErrors{{"username", "this username is taken, use another", ValidationCodeDuplicate}}
 			err = nil
 			return
 		}
 		if is, field := IsDbError1452(err); is {
-			verr = &ValidationError{field, "not exist"}
+			verr = ValidationErrors{{field, "not exist", ValidationCodeNotFound}}
 			err = nil
 			return
 		}
 		return
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(username, email /*string(hashedPassword)*/, password, enabled, displayName, ugroup)
-	if err != nil {
-		return
-	}
 	ok = true
 	return
 }
@@ -77,51 +77,49 @@ func (dbp *DBProvider) UserCheckLogin(username, password string) (user *User, ve
 	if err != nil {
 		if err == sql.ErrNoRows {
 			err = nil
-			verr = &ValidationError{"username/passsword", "not match"}
+			verr = &ValidationError{"username/passsword", "not match", ValidationCodeInvalidValue}
 			return
 		}
 		return
 	}
 	//err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if password != user.Password {
-		verr = &ValidationError{"username/passsword", "not match"}
+		verr = &ValidationError{"username/passsword", "not match", ValidationCodeInvalidValue}
 		return
 	}
 	return
 }
 
 func userValidateUsername(username string) (verr *ValidationError) {
-	// check is unique
-	return
+	// uniqueness is checked by UserCreate when the insert fails
+	if verr = validateNotEmpty("username", username); verr != nil {
+		return verr
+	}
+	return validateLength("username", username, 200)
 }
 func userValidateEmail(email string) (verr *ValidationError) {
-	// check is unique
-	return
+	if verr = validateNotEmpty("email", email); verr != nil {
+		return verr
+	}
+	if verr = validateLength("email", email, 200); verr != nil {
+		return verr
+	}
+	return validateEmail("email", email)
 }
 func userValidatePassword(password string) (verr *ValidationError) {
-	// check string lenght limit
-	return
+	// bcrypt ignores everything after the first 72 bytes
+	return validateLength("password", password, 72)
 }
 func userValidateDisplayName(dislpayName string) (verr *ValidationError) {
-	// check string lenght limit
-	return
-}
-func userValidate(username, email, password, displayName string) (verr *ValidationError) {
-	verr = userValidateUsername(username)
-	if verr != nil {
-		return
-	}
-	verr = userValidateEmail(email)
-	if verr != nil {
-		return
-	}
-	verr = userValidatePassword(password)
-	if verr != nil {
-		return
-	}
-	verr = userValidateDisplayName(displayName)
-	if verr != nil {
-		return
+	if verr = validateNotEmpty("display_name", dislpayName); verr != nil {
+		return verr
 	}
+	return validateLength("display_name", dislpayName, 200)
+}
+func UserValidate(username, email, password, displayName string) (verrs ValidationErrors) {
+	verrs.add(userValidateUsername(username))
+	verrs.add(userValidateEmail(email))
+	verrs.add(userValidatePassword(password))
+	verrs.add(userValidateDisplayName(displayName))
 	return
 }
@@ -1,35 +1,281 @@
 package instantolib
 
-import "fmt"
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	validateMinYear      = 1900
+	validateMaxYearAhead = 5
+)
 
 func validateDegree(field, value string) *ValidationError {
 	if value != "none" && value != "dr" && value != "dra" {
-		return &ValidationError{field, "value must be none, dr or dra"}
+		return &ValidationError{field, "value must be none, dr or dra", ValidationCodeInvalidValue}
 	}
 	return nil
 }
 
 func validateScope(field, value string) *ValidationError {
 	if value != "regional" && value != "national" && value != "international" {
-		return &ValidationError{field, "value must be regional, national or international"}
+		return &ValidationError{field, "value must be regional, national or international", ValidationCodeInvalidValue}
 	}
 	return nil
 }
 func validateNotEmpty(field, value string) *ValidationError {
 	if len(value) == 0 {
-		return &ValidationError{field, "cannot be empty"}
+		return &ValidationError{field, "cannot be empty", ValidationCodeRequired}
 	}
 	return nil
 }
 func validateIsNumber(field string, value int64) *ValidationError {
 	if value < 0 {
-		return &ValidationError{field, fmt.Sprintf("must be greater than 0")}
+		return &ValidationError{field, fmt.Sprintf("must be greater than 0"), ValidationCodeOutOfRange}
 	}
 	return nil
 }
 func validateLength(field, value string, length int) *ValidationError {
 	if len(value) > length {
-		return &ValidationError{field, fmt.Sprintf("length cannot be greater than %d", length)}
+		return &ValidationError{field, fmt.Sprintf("length cannot be greater than %d", length), ValidationCodeTooLong}
+	}
+	return nil
+}
+
+// validateEmail checks value is a bare RFC 5322 address, without display name. Empty values are valid.
+func validateEmail(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return &ValidationError{field, "must be a valid email address", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+// validateURL checks value is an absolute http or https URL. Empty values are valid.
+func validateURL(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return &ValidationError{field, "must be an absolute http or https URL", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+// validateYear checks value is between 1900 and five years from now
+func validateYear(field string, value int64) *ValidationError {
+	max := int64(time.Now().Year() + validateMaxYearAhead)
+	if value < validateMinYear || value > max {
+		return &ValidationError{field, fmt.Sprintf("must be between %d and %d", validateMinYear, max), ValidationCodeOutOfRange}
+	}
+	return nil
+}
+
+// validateDate checks value is a unix timestamp after 1900 and no later than one year from now
+func validateDate(field string, value int64) *ValidationError {
+	if value == 0 {
+		return &ValidationError{field, "cannot be empty", ValidationCodeRequired}
+	}
+	min := time.Date(validateMinYear, time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+	max := time.Now().AddDate(1, 0, 0).Unix()
+	if value < min || value > max {
+		return &ValidationError{field, fmt.Sprintf("must be between %d and one year from now", validateMinYear), ValidationCodeOutOfRange}
+	}
+	return nil
+}
+
+// validateNotBefore checks value is not before the value of the field other. A zero value is valid.
+func validateNotBefore(field string, value int64, other string, otherValue int64) *ValidationError {
+	if value != 0 && value < otherValue {
+		return &ValidationError{field, fmt.Sprintf("cannot be before %s", other), ValidationCodeOutOfRange}
 	}
 	return nil
 }
+
+// validateISBN checks the check digit of an ISBN-10 or ISBN-13. Hyphens and spaces are ignored and empty values are valid.
+func validateISBN(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	digits, ok := identifierDigits(value)
+	if ok && len(digits) == 10 && checkISBN10(digits) {
+		return nil
+	}
+	if ok && len(digits) == 13 && checkISBN13(digits) {
+		return nil
+	}
+	return &ValidationError{field, "must be a valid ISBN-10 or ISBN-13", ValidationCodeInvalidFormat}
+}
+
+// validateISSN checks the check digit of an ISSN. Hyphens and spaces are ignored and empty values are valid.
+func validateISSN(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	digits, ok := identifierDigits(value)
+	if ok && len(digits) == 8 && checkISSN(digits) {
+		return nil
+	}
+	return &ValidationError{field, "must be a valid ISSN", ValidationCodeInvalidFormat}
+}
+
+// identifierDigits strips the hyphens and spaces of an ISBN or ISSN.
+// ok is false when value contains other characters or an X that is not the last one.
+func identifierDigits(value string) (digits string, ok bool) {
+	digits = normalizeIdentifier(value)
+	for _, r := range value {
+		if (r < '0' || r > '9') && r != 'x' && r != 'X' && r != '-' && r != ' ' {
+			return digits, false
+		}
+	}
+	if i := strings.IndexByte(digits, 'X'); i != -1 && i != len(digits)-1 {
+		return digits, false
+	}
+	return digits, true
+}
+
+// normalizeIdentifier keeps only the digits and the X check character of an ISBN or ISSN
+func normalizeIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		if r == 'x' || r == 'X' {
+			return 'X'
+		}
+		return -1
+	}, s)
+}
+
+func checkDigitValue(r byte) int {
+	if r == 'X' {
+		return 10
+	}
+	return int(r - '0')
+}
+
+func checkISBN10(digits string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		sum += (10 - i) * checkDigitValue(digits[i])
+	}
+	return sum%11 == 0
+}
+
+func checkISBN13(digits string) bool {
+	if strings.IndexByte(digits, 'X') != -1 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 13; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * checkDigitValue(digits[i])
+	}
+	return sum%10 == 0
+}
+
+func checkISSN(digits string) bool {
+	sum := 0
+	for i := 0; i < 8; i++ {
+		sum += (8 - i) * checkDigitValue(digits[i])
+	}
+	return sum%11 == 0
+}
+
+var (
+	doiRegexp          = regexp.MustCompile(`^10\.[0-9]{4,9}/\S+$`)
+	orcidRegexp        = regexp.MustCompile(`^[0-9]{4}-[0-9]{4}-[0-9]{4}-[0-9]{3}[0-9X]$`)
+	scopusIdRegexp     = regexp.MustCompile(`^[0-9]{8,11}$`)
+	researcherIdRegexp = regexp.MustCompile(`^[A-Z]{1,3}-[0-9]{4}-(19|20)[0-9]{2}$`)
+)
+
+// NormalizeDoi removes the resolver or doi: prefix of a DOI and lowercases it, DOIs are case insensitive
+func NormalizeDoi(doi string) string {
+	doi = strings.TrimSpace(doi)
+	lower := strings.ToLower(doi)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		if strings.HasPrefix(lower, prefix) {
+			lower = strings.TrimSpace(lower[len(prefix):])
+			break
+		}
+	}
+	return lower
+}
+
+// NormalizeOrcid removes the orcid.org prefix of an ORCID iD
+func NormalizeOrcid(orcid string) string {
+	orcid = strings.ToUpper(strings.TrimSpace(orcid))
+	for _, prefix := range []string{"HTTPS://ORCID.ORG/", "HTTP://ORCID.ORG/"} {
+		if strings.HasPrefix(orcid, prefix) {
+			return orcid[len(prefix):]
+		}
+	}
+	return orcid
+}
+
+// validateDOI checks value is a normalized DOI. Empty values are valid.
+func validateDOI(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	if !doiRegexp.MatchString(value) {
+		return &ValidationError{field, "must be a valid DOI", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+// validateORCID checks the format and the ISO 7064 mod 11-2 check digit of an ORCID iD. Empty values are valid.
+func validateORCID(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	if !orcidRegexp.MatchString(value) || !checkOrcid(strings.Replace(value, "-", "", -1)) {
+		return &ValidationError{field, "must be a valid ORCID iD", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+// validateScopusId checks value is a Scopus Author ID. Empty values are valid.
+func validateScopusId(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	if !scopusIdRegexp.MatchString(value) {
+		return &ValidationError{field, "must be a valid Scopus Author ID", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+// validateResearcherId checks value is a Web of Science ResearcherID such as A-1234-2008. Empty values are valid.
+func validateResearcherId(field, value string) *ValidationError {
+	if value == "" {
+		return nil
+	}
+	if !researcherIdRegexp.MatchString(value) {
+		return &ValidationError{field, "must be a valid ResearcherID", ValidationCodeInvalidFormat}
+	}
+	return nil
+}
+
+func checkOrcid(digits string) bool {
+	total := 0
+	for i := 0; i < 15; i++ {
+		total = (total + int(digits[i]-'0')) * 2
+	}
+	check := (12 - total%11) % 11
+	if check == 10 {
+		return digits[15] == 'X'
+	}
+	return int(digits[15]-'0') == check
+}